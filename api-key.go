@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Scopes that can be granted to an API key. The admin scope grants every other
+// scope too.
+const (
+	scopeEventsWrite = "events:write"
+	scopeEventsRead  = "events:read"
+	scopeAdmin       = "admin"
+
+	apiKeyPrefix        = "et_"
+	apiKeyHeader        = "X-API-Key"
+	datadogAPIKeyHeader = "DD-API-KEY"
+
+	apiKeyContextKey contextKey = "api_key"
+
+	maxAPIKeyNameLength = 255
+)
+
+var (
+	errAPIKeyNotFound      = errors.New("API key not found")
+	errMissingAPIKey       = errors.New("an API key is required")
+	errInvalidAPIKey       = errors.New("API key is invalid or revoked")
+	errEventTypeNotAllowed = errors.New("API key may not record events of this type")
+
+	validScopes = map[string]bool{
+		scopeEventsWrite: true,
+		scopeEventsRead:  true,
+		scopeAdmin:       true,
+	}
+)
+
+// APIKey authenticates requests to /api/v0. Only a hash of the key is stored;
+// the key itself is returned once, when it is created.
+type APIKey struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// EventTypes optionally limits the event types that the key may record.
+	EventTypes []string  `json:"event_types"`
+	CreateTime time.Time `json:"create_time"`
+	RevokeTime NullTime  `json:"revoke_time"`
+	// Key is only set in the response to creating the key.
+	Key string `json:"key,omitempty"`
+
+	hash string
+}
+
+// Validate enforces minimum requirements for API keys that are created.
+func (k *APIKey) Validate() error {
+	if len(k.Name) == 0 {
+		return fmt.Errorf("name parameter is required")
+	} else if len(k.Name) > maxAPIKeyNameLength {
+		return fmt.Errorf("name must be at most %d characters", maxAPIKeyNameLength)
+	} else if len(k.Scopes) == 0 {
+		return fmt.Errorf("scopes parameter is required")
+	}
+
+	for _, scope := range k.Scopes {
+		if !validScopes[scope] {
+			return fmt.Errorf("scope \"%s\" must be one of \"%s\", \"%s\" or \"%s\"", scope, scopeEventsWrite, scopeEventsRead, scopeAdmin)
+		}
+	}
+
+	if k.EventTypes == nil {
+		k.EventTypes = []string{}
+	}
+	for _, eventType := range k.EventTypes {
+		if len(eventType) == 0 || strings.ToUpper(eventType) != eventType {
+			return fmt.Errorf("event_types must be upper case event type names")
+		}
+	}
+
+	return nil
+}
+
+// generate gives the key a new ID and secret.
+func (k *APIKey) generate() error {
+	id := make([]byte, 8)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	} else if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+
+	k.ID = hex.EncodeToString(id)
+	k.Key = apiKeyPrefix + k.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	k.hash = hashAPIKey(k.Key)
+	k.CreateTime = time.Now().UTC().Truncate(time.Second)
+
+	return nil
+}
+
+// hashAPIKey hashes a key for storage. Keys are long and random, so a fast hash
+// is enough.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// HasScope reports whether the key grants scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == scopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsEventType reports whether the key may record events of the type.
+func (k *APIKey) AllowsEventType(eventType string) bool {
+	if len(k.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range k.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyFromRequest reads the key from the Authorization header, or from the
+// headers used by clients of the compatible ingest routes.
+func apiKeyFromRequest(r *http.Request) string {
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	} else if key := r.Header.Get(apiKeyHeader); len(key) > 0 {
+		return key
+	}
+	return r.Header.Get(datadogAPIKeyHeader)
+}
+
+func apiKeyFromContext(ctx context.Context) (*APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey).(*APIKey)
+	return key, ok
+}
+
+// routeScope returns the scope required by a request to /api/v0. Routes that are
+// not listed require the admin scope, except for the GitHub, GitLab, Bitbucket
+// and Slack webhooks, which check their own signatures or tokens instead.
+func routeScope(r *http.Request) (string, bool) {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/api/v0/github"), strings.HasPrefix(path, "/api/v0/gitlab"), strings.HasPrefix(path, "/api/v0/bitbucket"), strings.HasPrefix(path, "/api/v0/slack"):
+		return "", false
+	case strings.HasPrefix(path, "/api/v0/record"), strings.HasPrefix(path, "/api/v0/compat"):
+		return scopeEventsWrite, true
+	case strings.HasPrefix(path, "/api/v0/grafana"):
+		return scopeEventsRead, true
+	case strings.HasPrefix(path, "/api/v0/events"):
+		if r.Method == http.MethodGet {
+			return scopeEventsRead, true
+		}
+		return scopeEventsWrite, true
+	case strings.HasPrefix(path, "/api/v0/event-types") && r.Method == http.MethodGet:
+		return scopeEventsRead, true
+	}
+	return scopeAdmin, true
+}
+
+// apiKeyMiddleware checks that requests carry an unrevoked API key with the
+// scope that the route requires, and stores the key in the request context.
+func (s *server) apiKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, ok := routeScope(r)
+		if !ok || !*s.RequireAPIKey {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		raw := apiKeyFromRequest(r)
+		if len(raw) == 0 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondWithJSON(w, http.StatusUnauthorized, errMissingAPIKey, "", nil)
+			return
+		}
+
+		key, err := s.store.GetAPIKeyByHash(r.Context(), hashAPIKey(raw))
+		if errors.Is(err, errAPIKeyNotFound) {
+			w.Header().Set("WWW-Authenticate", "Bearer error=\"invalid_token\"")
+			respondWithJSON(w, http.StatusUnauthorized, errInvalidAPIKey, "", nil)
+			return
+		} else if err != nil {
+			respondWithJSON(
+				w,
+				http.StatusInternalServerError,
+				err,
+				"failed to read from database",
+				nil,
+			)
+			return
+		}
+
+		if !key.HasScope(scope) {
+			respondWithJSON(w, http.StatusForbidden, fmt.Errorf("API key lacks the \"%s\" scope", scope), "", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, key)))
+	})
+}
+
+// authorizeEvent records which API key, if any, is creating the event, and
+// checks that the key may record events of its type.
+func authorizeEvent(ctx context.Context, event *Event) error {
+	event.APIKeyID = ""
+
+	key, ok := apiKeyFromContext(ctx)
+	if !ok {
+		return nil
+	} else if err := authorizeEventType(ctx, event.EventType); err != nil {
+		return err
+	}
+
+	event.APIKeyID = key.ID
+	return nil
+}
+
+// authorizeEventType checks that the API key, if any, may record, update and
+// delete events of the type.
+func authorizeEventType(ctx context.Context, eventType string) error {
+	if key, ok := apiKeyFromContext(ctx); ok && !key.AllowsEventType(eventType) {
+		return fmt.Errorf("%w: \"%s\"", errEventTypeNotAllowed, eventType)
+	}
+	return nil
+}
+
+// APIKeys implements the "api-keys create|list|revoke" subcommand.
+func (s *server) APIKeys(args []string) {
+	if *s.Store == storeMemory {
+		fatal(fmt.Sprintf("api-keys needs a database; store \"%s\" is not backed by one", storeMemory))
+	}
+	s.initEventStore()
+	defer s.store.Close()
+
+	var action string
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	ctx := context.Background()
+	var err error
+	switch action {
+	case "create":
+		fs := flag.NewFlagSet("api-keys create", flag.ExitOnError)
+		name := fs.String("name", "", "what the key is for")
+		scopes := fs.String("scopes", scopeEventsWrite, "comma separated scopes to grant")
+		eventTypes := fs.String("event-types", "", "comma separated event types that the key may record (default any)")
+		fs.Parse(args)
+
+		key := &APIKey{Name: *name, Scopes: splitList(*scopes), EventTypes: splitList(*eventTypes)}
+		if err = key.Validate(); err == nil {
+			if err = key.generate(); err == nil {
+				err = s.store.CreateAPIKey(ctx, key)
+			}
+		}
+		if err == nil {
+			fmt.Printf("%s\n", key.Key)
+		}
+	case "list":
+		var keys []APIKey
+		if keys, err = s.store.ListAPIKeys(ctx); err == nil {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tNAME\tSCOPES\tEVENT TYPES\tCREATED\tREVOKED")
+			for _, key := range keys {
+				revoked := ""
+				if key.RevokeTime.Valid {
+					revoked = key.RevokeTime.Time.Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, strings.Join(key.Scopes, ","), strings.Join(key.EventTypes, ","), key.CreateTime.Format(time.RFC3339), revoked)
+			}
+			err = w.Flush()
+		}
+	case "revoke":
+		if len(args) != 1 {
+			err = fmt.Errorf("usage: api-keys revoke <id>")
+		} else {
+			err = s.store.RevokeAPIKey(ctx, args[0])
+		}
+	default:
+		err = fmt.Errorf("usage: api-keys create|list|revoke")
+	}
+
+	if err != nil {
+		fatal("api-keys failed", "action", action, "error", err.Error())
+	}
+}
+
+// splitList splits a comma separated list, ignoring empty items.
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (s *server) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.store.ListAPIKeys(r.Context())
+	if err != nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to read from database",
+			nil,
+		)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", keys)
+}
+
+// CreateAPIKeyHandler creates a key. The response is the only place where the
+// key itself is ever shown.
+func (s *server) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key := APIKey{}
+	if err := json.NewDecoder(r.Body).Decode(&key); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if err := key.Validate(); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if err := key.generate(); err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, err, "failed to generate API key", nil)
+		return
+	}
+
+	if err := s.store.CreateAPIKey(r.Context(), &key); err != nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to write to database",
+			nil,
+		)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, nil, "", &key)
+}
+
+func (s *server) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.RevokeAPIKey(r.Context(), mux.Vars(r)["id"]); errors.Is(err, errAPIKeyNotFound) {
+		respondWithJSON(w, http.StatusNotFound, err, "", nil)
+		return
+	} else if err != nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to write to database",
+			nil,
+		)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", nil)
+}
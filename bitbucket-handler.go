@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const vcsBitbucket = "bitbucket"
+
+// bitbucketTime reads the timestamps of Bitbucket webhooks. Bitbucket Cloud
+// sends RFC 3339 timestamps, but Bitbucket Server leaves the colon out of the
+// offset, e.g. "2017-09-19T09:58:11+1000".
+type bitbucketTime struct {
+	time.Time
+}
+
+func (t *bitbucketTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	} else if len(s) == 0 {
+		return nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05-0700"} {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid Bitbucket time %q", s)
+}
+
+// BitbucketUser is a Bitbucket Cloud user. Nickname is their username.
+type BitbucketUser struct {
+	DisplayName string `json:"display_name"`
+	Nickname    string `json:"nickname"`
+}
+
+func (u BitbucketUser) name() string {
+	if len(u.Nickname) > 0 {
+		return u.Nickname
+	}
+	return u.DisplayName
+}
+
+type BitbucketLink struct {
+	Href string `json:"href"`
+}
+
+// BitbucketRepositoryData is the part of every Bitbucket Cloud webhook payload
+// that identifies the repository and who caused the webhook.
+type BitbucketRepositoryData struct {
+	Actor      BitbucketUser `json:"actor"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		Links    struct {
+			HTML BitbucketLink `json:"html"`
+		} `json:"links"`
+	} `json:"repository"`
+}
+
+type BitbucketCommit struct {
+	Hash    string        `json:"hash"`
+	Message string        `json:"message"`
+	Date    bitbucketTime `json:"date"`
+	Links   struct {
+		HTML BitbucketLink `json:"html"`
+	} `json:"links"`
+	Author struct {
+		Raw  string        `json:"raw"`
+		User BitbucketUser `json:"user"`
+	} `json:"author"`
+}
+
+// BitbucketPushData is the payload of Bitbucket Cloud repo:push webhooks. A push
+// may change several branches and tags at once.
+type BitbucketPushData struct {
+	BitbucketRepositoryData
+	Push struct {
+		Changes []struct {
+			New *struct {
+				Type   string          `json:"type"`
+				Name   string          `json:"name"`
+				Target BitbucketCommit `json:"target"`
+			} `json:"new"`
+			Commits []BitbucketCommit `json:"commits"`
+		} `json:"changes"`
+	} `json:"push"`
+}
+
+type BitbucketPullRequestData struct {
+	BitbucketRepositoryData
+	PullRequest struct {
+		ID          int64         `json:"id"`
+		Title       string        `json:"title"`
+		Description string        `json:"description"`
+		UpdatedOn   bitbucketTime `json:"updated_on"`
+		Author      BitbucketUser `json:"author"`
+		Links       struct {
+			HTML BitbucketLink `json:"html"`
+		} `json:"links"`
+		Source struct {
+			Branch struct {
+				Name string `json:"name"`
+			} `json:"branch"`
+		} `json:"source"`
+		Destination struct {
+			Branch struct {
+				Name string `json:"name"`
+			} `json:"branch"`
+		} `json:"destination"`
+		MergeCommit struct {
+			Hash string `json:"hash"`
+		} `json:"merge_commit"`
+	} `json:"pullrequest"`
+}
+
+// BitbucketRefsChangedData is the payload of Bitbucket Server repo:refs_changed
+// webhooks, which are sent for pushes. Unlike Bitbucket Cloud, they do not have
+// the pushed commits.
+type BitbucketRefsChangedData struct {
+	Date  bitbucketTime `json:"date"`
+	Actor struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"actor"`
+	Repository struct {
+		Slug    string `json:"slug"`
+		Project struct {
+			Key string `json:"key"`
+		} `json:"project"`
+		Links struct {
+			Self []BitbucketLink `json:"self"`
+		} `json:"links"`
+	} `json:"repository"`
+	Changes []struct {
+		Ref struct {
+			ID        string `json:"id"`
+			DisplayID string `json:"displayId"`
+			Type      string `json:"type"`
+		} `json:"ref"`
+		FromHash string `json:"fromHash"`
+		ToHash   string `json:"toHash"`
+		// Type is "ADD", "UPDATE" or "DELETE".
+		Type string `json:"type"`
+	} `json:"changes"`
+}
+
+// recordsBitbucketBranch reports whether pushes to the branch are recorded.
+func (s *server) recordsBitbucketBranch(branch string) bool {
+	for _, b := range s.BitbucketBranches {
+		if b == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// BitbucketPushHandler records Bitbucket Cloud pushes to the branches named by
+// --bitbucket-branches as PUSH events, as PushHandler does for GitHub. A push
+// that changes several of them is recorded once for each.
+func (s *server) BitbucketPushHandler(w http.ResponseWriter, r *http.Request) {
+	request := BitbucketPushData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	events := []*Event{}
+	// Deleted branches have no new state, and tags are not pushes.
+	for _, change := range request.Push.Changes {
+		if change.New == nil || change.New.Type != "branch" || !s.recordsBitbucketBranch(change.New.Name) {
+			continue
+		}
+
+		head := change.New.Target
+		metadata := &VCSMetadata{
+			VCS:           vcsBitbucket,
+			VCSEvent:      "push",
+			Repository:    request.Repository.FullName,
+			RepositoryURL: request.Repository.Links.HTML.Href,
+			Ref:           "refs/heads/" + change.New.Name,
+			SHA:           head.Hash,
+			Author:        request.Actor.name(),
+			Title:         strings.SplitN(strings.TrimSpace(head.Message), "\n", 2)[0],
+			URL:           head.Links.HTML.Href,
+			Commits:       []VCSCommit{},
+		}
+		for _, commit := range change.Commits {
+			author := commit.Author.User.name()
+			if len(author) == 0 {
+				author = commit.Author.Raw
+			}
+			metadata.Commits = append(metadata.Commits, VCSCommit{SHA: commit.Hash, Message: commit.Message, Author: author, URL: commit.Links.HTML.Href})
+		}
+
+		event := &Event{
+			EventType: eventTypePush,
+			StartTime: head.Date.Time,
+			Notes:     strings.TrimSpace(head.Message),
+			Metadata:  metadata,
+		}
+		if uuid := r.Header.Get(bitbucketRequestUUIDHeader); len(uuid) > 0 {
+			event.IdempotencyKey = "bitbucket:" + uuid + ":" + metadata.Ref
+		}
+		events = append(events, event)
+	}
+
+	s.recordBitbucketEvents(w, r, events)
+}
+
+// BitbucketPullRequestHandler records merged Bitbucket Cloud pull requests as
+// MERGE events.
+func (s *server) BitbucketPullRequestHandler(w http.ResponseWriter, r *http.Request) {
+	request := BitbucketPullRequestData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	pr := request.PullRequest
+	event := &Event{
+		EventType: eventTypeMerge,
+		StartTime: pr.UpdatedOn.Time,
+		Notes:     pr.Title,
+		Metadata: &VCSMetadata{
+			VCS:           vcsBitbucket,
+			VCSEvent:      "pull_request",
+			Repository:    request.Repository.FullName,
+			RepositoryURL: request.Repository.Links.HTML.Href,
+			Ref:           "refs/heads/" + pr.Destination.Branch.Name,
+			SHA:           pr.MergeCommit.Hash,
+			Author:        pr.Author.name(),
+			Action:        "merged",
+			ID:            pr.ID,
+			Title:         pr.Title,
+			Description:   pr.Description,
+			URL:           pr.Links.HTML.Href,
+			SourceBranch:  pr.Source.Branch.Name,
+			TargetBranch:  pr.Destination.Branch.Name,
+		},
+	}
+	if uuid := r.Header.Get(bitbucketRequestUUIDHeader); len(uuid) > 0 {
+		event.IdempotencyKey = "bitbucket:" + uuid
+	}
+
+	s.recordBitbucketEvents(w, r, []*Event{event})
+}
+
+// BitbucketRefsChangedHandler records Bitbucket Server pushes to the branches
+// named by --bitbucket-branches as PUSH events, once for each branch changed.
+func (s *server) BitbucketRefsChangedHandler(w http.ResponseWriter, r *http.Request) {
+	request := BitbucketRefsChangedData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	// Bitbucket Server names repositories by their project key and slug.
+	repository := request.Repository.Project.Key + "/" + request.Repository.Slug
+	repositoryURL := ""
+	if len(request.Repository.Links.Self) > 0 {
+		repositoryURL = strings.TrimSuffix(request.Repository.Links.Self[0].Href, "/browse")
+	}
+	author := request.Actor.Name
+	if len(author) == 0 {
+		author = request.Actor.DisplayName
+	}
+
+	events := []*Event{}
+	for _, change := range request.Changes {
+		if change.Type == "DELETE" || change.Ref.Type != "BRANCH" || !s.recordsBitbucketBranch(change.Ref.DisplayID) {
+			continue
+		}
+
+		metadata := &VCSMetadata{
+			VCS:           vcsBitbucket,
+			VCSEvent:      "push",
+			Repository:    repository,
+			RepositoryURL: repositoryURL,
+			Ref:           change.Ref.ID,
+			SHA:           change.ToHash,
+			Author:        author,
+			Commits:       []VCSCommit{},
+		}
+		if len(repositoryURL) > 0 {
+			metadata.URL = repositoryURL + "/commits/" + change.ToHash
+		}
+
+		events = append(events, &Event{
+			EventType: eventTypePush,
+			StartTime: request.Date.Time,
+			Notes:     fmt.Sprintf("Push to %s %s", repository, change.Ref.DisplayID),
+			Metadata:  metadata,
+			// Bitbucket Server does not identify its deliveries, so a change of
+			// a ref is recorded once by the hashes it moved between.
+			IdempotencyKey: "bitbucket-push:" + repository + ":" + change.Ref.ID + ":" +
+				change.FromHash + ":" + change.ToHash,
+		})
+	}
+
+	s.recordBitbucketEvents(w, r, events)
+}
+
+// recordBitbucketEvents records the events from a Bitbucket webhook, unless
+// their event type has been unregistered. Each event has its own idempotency
+// key, so that a redelivery after a failure only records the events that were
+// not recorded the first time.
+func (s *server) recordBitbucketEvents(w http.ResponseWriter, r *http.Request, events []*Event) {
+	for _, event := range events {
+		if _, ok := s.eventTypes.Lookup(event.EventType); !ok {
+			respondWithJSON(w, http.StatusOK, nil, fmt.Sprintf("event type \"%s\" is not registered", event.EventType), event.Metadata)
+			return
+		}
+
+		event.fromIntegration = true
+		if err := s.writeToDBAndLog(r.Context(), event); err != nil {
+			respondWithWriteError(w, err)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", events)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveBitbucket sends a Bitbucket webhook signed with testBitbucketSecret,
+// with the headers given.
+func (s *server) serveBitbucket(t *testing.T, event string, headers map[string]string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("failed to marshal webhook: %v", err)
+	}
+	mac := hmac.New(sha256.New, []byte(testBitbucketSecret))
+	mac.Write(b)
+
+	all := map[string]string{
+		bitbucketEventHeader:     event,
+		bitbucketSignatureHeader: "sha256=" + hex.EncodeToString(mac.Sum(nil)),
+	}
+	for name, value := range headers {
+		all[name] = value
+	}
+	return s.serve(t, http.MethodPost, "/api/v0/bitbucket", b, all)
+}
+
+func testBitbucketChange(kind, name, hash, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"new": map[string]interface{}{
+			"type": kind,
+			"name": name,
+			"target": map[string]interface{}{
+				"hash":    hash,
+				"message": message,
+				"date":    "2024-03-01T12:00:00+00:00",
+			},
+		},
+		"commits": []map[string]interface{}{
+			{"hash": hash, "message": message, "author": map[string]interface{}{"raw": "Octo Cat <octocat@example.com>"}},
+		},
+	}
+}
+
+func testBitbucketRefChange(branch, fromHash, toHash string) map[string]interface{} {
+	return map[string]interface{}{
+		"ref":      map[string]interface{}{"id": "refs/heads/" + branch, "displayId": branch, "type": "BRANCH"},
+		"fromHash": fromHash,
+		"toHash":   toHash,
+		"type":     "UPDATE",
+	}
+}
+
+func TestBitbucketPushHandler(t *testing.T) {
+	s := newTestServer(t)
+
+	push := map[string]interface{}{
+		"actor":      map[string]interface{}{"nickname": "octocat"},
+		"repository": map[string]interface{}{"full_name": "octo/app"},
+		"push": map[string]interface{}{
+			"changes": []map[string]interface{}{
+				testBitbucketChange("branch", "main", "a10867b", "Fix typo"),
+				testBitbucketChange("branch", "feature", "b20867b", "Start feature"),
+				testBitbucketChange("tag", "main", "c30867b", "Not a branch"),
+				testBitbucketChange("branch", "master", "d40867b", "Sync master"),
+			},
+		},
+	}
+	delivery := map[string]string{bitbucketRequestUUIDHeader: "uuid-1"}
+	expectStatus(t, s.serveBitbucket(t, bitbucketPushEvent, delivery, push), http.StatusOK)
+	// Redeliveries are recorded once.
+	expectStatus(t, s.serveBitbucket(t, bitbucketPushEvent, delivery, push), http.StatusOK)
+
+	// A request ID is not a delivery ID, so pushes that share one are each
+	// recorded.
+	for _, hash := range []string{"e50867b", "f60867b"} {
+		expectStatus(t, s.serveBitbucket(t, bitbucketPushEvent, map[string]string{requestIDHeader: "request-1"}, map[string]interface{}{
+			"repository": map[string]interface{}{"full_name": "octo/app"},
+			"push": map[string]interface{}{
+				"changes": []map[string]interface{}{testBitbucketChange("branch", "main", hash, "Fix another typo")},
+			},
+		}), http.StatusOK)
+	}
+
+	events := storedEvents(t, s)
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want one for each push to main or master", len(events))
+	}
+	for i, want := range []struct {
+		ref, sha string
+	}{
+		{"refs/heads/main", "a10867b"},
+		{"refs/heads/master", "d40867b"},
+		{"refs/heads/main", "e50867b"},
+		{"refs/heads/main", "f60867b"},
+	} {
+		if metadata := metadataOf(t, events[i]); metadata["ref"] != want.ref || metadata["sha"] != want.sha {
+			t.Errorf("event %d has metadata %v, want %s at %s", i, metadata, want.ref, want.sha)
+		}
+	}
+}
+
+func TestBitbucketRefsChangedHandler(t *testing.T) {
+	s := newTestServer(t)
+
+	refsChanged := map[string]interface{}{
+		"date":  "2024-03-01T12:00:00+1000",
+		"actor": map[string]interface{}{"name": "octocat"},
+		"repository": map[string]interface{}{
+			"slug":    "app",
+			"project": map[string]interface{}{"key": "OCTO"},
+		},
+		"changes": []map[string]interface{}{
+			testBitbucketRefChange("main", "a10867b", "b20867b"),
+			testBitbucketRefChange("feature", "a10867b", "c30867b"),
+			testBitbucketRefChange("master", "a10867b", "b20867b"),
+		},
+	}
+	expectStatus(t, s.serveBitbucket(t, bitbucketServerRefsChangedEvent, map[string]string{requestIDHeader: "request-1"}, refsChanged), http.StatusOK)
+	// Redeliveries are recorded once, however they are identified.
+	expectStatus(t, s.serveBitbucket(t, bitbucketServerRefsChangedEvent, map[string]string{requestIDHeader: "request-2"}, refsChanged), http.StatusOK)
+
+	events := storedEvents(t, s)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want one for each push to main or master", len(events))
+	}
+	for i, ref := range []string{"refs/heads/main", "refs/heads/master"} {
+		if metadata := metadataOf(t, events[i]); metadata["ref"] != ref || metadata["repository"] != "OCTO/app" {
+			t.Errorf("event %d has metadata %v, want a push to %s", i, metadata, ref)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	// Bitbucket signs webhooks in the header that GitHub uses for SHA-1, but with
+	// SHA-256.
+	bitbucketSignatureHeader   = signatureSHA1Header
+	bitbucketEventHeader       = "X-Event-Key"
+	bitbucketRequestUUIDHeader = "X-Request-UUID"
+
+	bitbucketPushEvent              = "repo:push"
+	bitbucketPullRequestMergedEvent = "pullrequest:fulfilled"
+	bitbucketServerRefsChangedEvent = "repo:refs_changed"
+	bitbucketServerPingEvent        = "diagnostics:ping"
+)
+
+var (
+	validBitbucketEvents = map[string]bool{
+		bitbucketPushEvent:              true,
+		bitbucketPullRequestMergedEvent: true,
+		bitbucketServerRefsChangedEvent: true,
+		bitbucketServerPingEvent:        true,
+	}
+)
+
+// BitbucketWebHookValidator checks the signatures of Bitbucket Cloud and
+// Bitbucket Server webhooks. Any of Secrets is accepted, so that the secret can
+// be rotated without downtime.
+type BitbucketWebHookValidator struct {
+	Secrets [][]byte
+}
+
+func (v *BitbucketWebHookValidator) verifySignature(signature string, body []byte) bool {
+	const signaturePrefix = "sha256="
+	const signatureLength = 71 // len(SignaturePrefix) + len(hex(sha256))
+
+	if len(signature) != signatureLength || !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+
+	actual := make([]byte, 32)
+	hex.Decode(actual, []byte(signature[7:]))
+
+	for _, secret := range v.Secrets {
+		computed := hmac.New(sha256.New, secret)
+		computed.Write(body)
+		if hmac.Equal(computed.Sum(nil), actual) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *BitbucketWebHookValidator) parseHook(req *http.Request) error {
+	signature := req.Header.Get(bitbucketSignatureHeader)
+	if len(signature) == 0 {
+		return fmt.Errorf("Missing \"%s\" header", bitbucketSignatureHeader)
+	}
+
+	payload, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(payload))
+
+	if !v.verifySignature(signature, payload) {
+		return errors.New("Invalid SHA256 signature")
+	}
+
+	bitbucketEvent := req.Header.Get(bitbucketEventHeader)
+	if !validBitbucketEvents[bitbucketEvent] {
+		logger(req.Context()).Info("Bitbucket event type not handled", "bitbucket_event", bitbucketEvent)
+	}
+
+	return nil
+}
+
+func (v *BitbucketWebHookValidator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracer.Start(r.Context(), "BitbucketWebHookValidator")
+		err := v.parseHook(r)
+		endSpan(span, err)
+		if err != nil {
+			respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CloudEvents 1.0 support. Events can be recorded in structured mode, where the
+// whole CloudEvent is the JSON body, or in binary mode, where the attributes are
+// ce-* headers and the body is the data. See https://cloudevents.io.
+const (
+	applicationCloudEvents      = "application/cloudevents+json"
+	applicationCloudEventsBatch = "application/cloudevents-batch+json"
+
+	cloudEventsSpecVersion  = "1.0"
+	cloudEventsHeaderPrefix = "Ce-"
+	// cloudEventsSource is the source of every CloudEvent emitted by the read API.
+	// Event IDs are unique within it.
+	cloudEventsSource = "/api/v0/events"
+)
+
+// CloudEvent is a CloudEvent with JSON data. EndTime is an extension attribute
+// carrying the event's end_time.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+	EndTime         *time.Time      `json:"endtime,omitempty"`
+}
+
+// Validate enforces minimum requirements for CloudEvents that are recorded.
+func (c *CloudEvent) Validate() error {
+	if c.SpecVersion != cloudEventsSpecVersion {
+		return fmt.Errorf("specversion must be \"%s\"", cloudEventsSpecVersion)
+	} else if len(c.ID) == 0 {
+		return fmt.Errorf("id attribute is required")
+	} else if len(c.Source) == 0 {
+		return fmt.Errorf("source attribute is required")
+	} else if len(c.Type) == 0 {
+		return fmt.Errorf("type attribute is required")
+	} else if len(c.DataBase64) > 0 {
+		return fmt.Errorf("only JSON data is supported; data_base64 is not")
+	}
+
+	if len(c.DataContentType) > 0 {
+		mediaType, _, err := mime.ParseMediaType(c.DataContentType)
+		if err != nil || mediaType != applicationJSON && !strings.HasSuffix(mediaType, "+json") {
+			return fmt.Errorf("datacontenttype must be JSON")
+		}
+	}
+
+	return nil
+}
+
+// Event maps the CloudEvent onto an event. The type is upper cased to match the
+// event type registry, and source and id together become the idempotency key.
+func (c *CloudEvent) Event() *Event {
+	event := &Event{
+		EventType:      strings.ToUpper(c.Type),
+		Notes:          c.Subject,
+		IdempotencyKey: "cloudevents:" + c.Source + "\n" + c.ID,
+	}
+
+	if c.Time != nil {
+		event.StartTime = *c.Time
+	}
+
+	if c.EndTime != nil {
+		event.EndTime.Time = *c.EndTime
+		event.EndTime.Valid = true
+	}
+
+	if len(c.Data) > 0 {
+		event.Metadata = c.Data
+	}
+
+	return event
+}
+
+// newCloudEvent is the CloudEvent for an event returned by the read API.
+func newCloudEvent(event *Event) (*CloudEvent, error) {
+	startTime := event.StartTime.UTC()
+	c := &CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              strconv.FormatInt(event.ID, 10),
+		Source:          cloudEventsSource,
+		Type:            event.EventType,
+		Subject:         event.Notes,
+		Time:            &startTime,
+		DataContentType: applicationJSON,
+	}
+
+	if event.EndTime.Valid {
+		endTime := event.EndTime.Time.UTC()
+		c.EndTime = &endTime
+	}
+
+	if event.Metadata != nil {
+		data, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal metadata to []byte")
+		}
+		c.Data = data
+	}
+
+	return c, nil
+}
+
+// isCloudEvent reports whether the request carries a CloudEvent in either mode.
+func isCloudEvent(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(contentTypeHeader))
+	return mediaType == applicationCloudEvents || len(r.Header.Get(cloudEventsHeaderPrefix+"Specversion")) > 0
+}
+
+// readCloudEvent reads a CloudEvent from the request in either mode.
+func readCloudEvent(r *http.Request) (*CloudEvent, error) {
+	c := &CloudEvent{}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(contentTypeHeader))
+	if mediaType == applicationCloudEvents {
+		if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+			return nil, err
+		}
+		return c, c.Validate()
+	}
+
+	c.SpecVersion = r.Header.Get(cloudEventsHeaderPrefix + "Specversion")
+	c.ID = r.Header.Get(cloudEventsHeaderPrefix + "Id")
+	c.Source = r.Header.Get(cloudEventsHeaderPrefix + "Source")
+	c.Type = r.Header.Get(cloudEventsHeaderPrefix + "Type")
+	c.Subject = r.Header.Get(cloudEventsHeaderPrefix + "Subject")
+	c.DataContentType = r.Header.Get(contentTypeHeader)
+
+	for name, t := range map[string]**time.Time{"Time": &c.Time, "Endtime": &c.EndTime} {
+		value := r.Header.Get(cloudEventsHeaderPrefix + name)
+		if len(value) == 0 {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("ce-%s must be an RFC3339 time", strings.ToLower(name))
+		}
+		*t = &parsed
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if data = bytes.TrimSpace(data); len(data) > 0 {
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("data must be JSON")
+		}
+		c.Data = data
+	}
+
+	return c, c.Validate()
+}
+
+// acceptsCloudEvents reports whether the client asked for the given CloudEvents
+// media type rather than the usual JSON envelope.
+func acceptsCloudEvents(r *http.Request, mediaType string) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		if accepted, _, err := mime.ParseMediaType(accepted); err == nil && accepted == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// respondWithCloudEvents writes events without the usual envelope: a single
+// CloudEvent in structured mode, or a JSON array of them in batch mode.
+func respondWithCloudEvents(w http.ResponseWriter, mediaType string, events ...Event) {
+	cloudEvents := make([]*CloudEvent, len(events))
+	for i := range events {
+		c, err := newCloudEvent(&events[i])
+		if err != nil {
+			respondWithJSON(w, http.StatusInternalServerError, err, "", nil)
+			return
+		}
+		cloudEvents[i] = c
+	}
+
+	w.Header().Set(contentTypeHeader, mediaType)
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	if mediaType == applicationCloudEvents && len(cloudEvents) == 1 {
+		encoder.Encode(cloudEvents[0])
+		return
+	}
+	encoder.Encode(cloudEvents)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventTypeTag is the tag prefix that names the event type explicitly, e.g.
+// "event_type:deployment".
+const eventTypeTag = "event_type:"
+
+// GrafanaAnnotationPost is the body of Grafana's POST /api/annotations.
+type GrafanaAnnotationPost struct {
+	DashboardUID string   `json:"dashboardUID"`
+	PanelID      int64    `json:"panelId"`
+	Time         int64    `json:"time"`
+	TimeEnd      int64    `json:"timeEnd"`
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
+}
+
+// DatadogEventPost is the body of Datadog's POST /api/v1/events.
+type DatadogEventPost struct {
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	Tags           []string `json:"tags"`
+	DateHappened   int64    `json:"date_happened"`
+	AlertType      string   `json:"alert_type"`
+	Priority       string   `json:"priority"`
+	Host           string   `json:"host"`
+	AggregationKey string   `json:"aggregation_key"`
+	SourceTypeName string   `json:"source_type_name"`
+}
+
+// inferEventType picks the event type from an "event_type:<name>" tag or, failing
+// that, from the first tag that names a registered event type. It returns the
+// remaining tags.
+func inferEventType(tags []string, eventTypes *EventTypeRegistry) (string, []string, error) {
+	for i, tag := range tags {
+		if strings.HasPrefix(strings.ToLower(tag), eventTypeTag) {
+			eventType := strings.ToUpper(strings.TrimSpace(tag[len(eventTypeTag):]))
+			return eventType, append(append([]string{}, tags[:i]...), tags[i+1:]...), nil
+		}
+	}
+
+	for i, tag := range tags {
+		eventType := strings.ToUpper(strings.NewReplacer("_", " ", "-", " ").Replace(tag))
+		if _, ok := eventTypes.Lookup(eventType); ok {
+			return eventType, append(append([]string{}, tags[:i]...), tags[i+1:]...), nil
+		}
+	}
+
+	return "", tags, invalidEventError{fmt.Errorf(
+		"could not infer event_type from tags; add an \"%s<name>\" tag with one of \"%s\"",
+		eventTypeTag,
+		strings.Join(eventTypes.Names(), "\", \""),
+	)}
+}
+
+// tagsToMetadata turns "key:value" tags into metadata fields and collects any
+// other tags under "tags".
+func tagsToMetadata(tags []string) map[string]interface{} {
+	metadata := map[string]interface{}{}
+	plain := []string{}
+	for _, tag := range tags {
+		if i := strings.Index(tag, ":"); i > 0 {
+			metadata[tag[:i]] = tag[i+1:]
+		} else if len(tag) > 0 {
+			plain = append(plain, tag)
+		}
+	}
+
+	if len(plain) > 0 {
+		metadata["tags"] = plain
+	}
+
+	return metadata
+}
+
+func (a *GrafanaAnnotationPost) Event(eventTypes *EventTypeRegistry) (*Event, error) {
+	eventType, tags, err := inferEventType(a.Tags, eventTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := tagsToMetadata(tags)
+	if len(a.DashboardUID) > 0 {
+		metadata["dashboard_uid"] = a.DashboardUID
+	}
+	if a.PanelID != 0 {
+		metadata["panel_id"] = a.PanelID
+	}
+
+	event := &Event{
+		EventType:       eventType,
+		Notes:           a.Text,
+		Metadata:        metadata,
+		fromIntegration: true,
+	}
+	if a.Time != 0 {
+		event.StartTime = time.Unix(0, a.Time*int64(time.Millisecond))
+	}
+	if a.TimeEnd != 0 {
+		event.EndTime.Time = time.Unix(0, a.TimeEnd*int64(time.Millisecond))
+		event.EndTime.Valid = true
+	}
+
+	return event, nil
+}
+
+func (d *DatadogEventPost) Event(eventTypes *EventTypeRegistry) (*Event, error) {
+	eventType, tags, err := inferEventType(d.Tags, eventTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := tagsToMetadata(tags)
+	for key, value := range map[string]string{
+		"text":             d.Text,
+		"alert_type":       d.AlertType,
+		"priority":         d.Priority,
+		"host":             d.Host,
+		"aggregation_key":  d.AggregationKey,
+		"source_type_name": d.SourceTypeName,
+	} {
+		if len(value) > 0 {
+			metadata[key] = value
+		}
+	}
+
+	event := &Event{
+		EventType:       eventType,
+		Notes:           d.Title,
+		Metadata:        metadata,
+		fromIntegration: true,
+	}
+	if d.DateHappened != 0 {
+		event.StartTime = time.Unix(d.DateHappened, 0)
+	}
+
+	return event, nil
+}
+
+// recordCompatEvent records an event translated from another tool's format. It
+// only writes a response on failure, leaving success to the caller so that it
+// can answer in the format the tool expects.
+func (s *server) recordCompatEvent(w http.ResponseWriter, r *http.Request, event *Event, err error) bool {
+	if err == nil {
+		if err := setClientIdempotencyKey(r, event); err != nil {
+			respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+			return false
+		}
+		err = s.writeToDBAndLog(r.Context(), event)
+	}
+
+	if err != nil {
+		respondWithWriteError(w, err)
+		return false
+	}
+
+	return true
+}
+
+// GrafanaAnnotationPostHandler accepts the body of Grafana's POST
+// /api/annotations and responds like Grafana does.
+func (s *server) GrafanaAnnotationPostHandler(w http.ResponseWriter, r *http.Request) {
+	annotation := GrafanaAnnotationPost{}
+	if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	event, err := annotation.Event(s.eventTypes)
+	if !s.recordCompatEvent(w, r, event, err) {
+		return
+	}
+
+	w.Header().Set(contentTypeHeader, applicationJSON)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Annotation added",
+		"id":      strconv.FormatInt(event.ID, 10),
+	})
+}
+
+// DatadogEventPostHandler accepts the body of Datadog's POST /api/v1/events and
+// responds like Datadog does.
+func (s *server) DatadogEventPostHandler(w http.ResponseWriter, r *http.Request) {
+	datadogEvent := DatadogEventPost{}
+	if err := json.NewDecoder(r.Body).Decode(&datadogEvent); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	event, err := datadogEvent.Event(s.eventTypes)
+	if !s.recordCompatEvent(w, r, event, err) {
+		return
+	}
+
+	w.Header().Set(contentTypeHeader, applicationJSON)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"event": map[string]interface{}{
+			"id":            strconv.FormatInt(event.ID, 10),
+			"title":         event.Notes,
+			"text":          datadogEvent.Text,
+			"tags":          datadogEvent.Tags,
+			"date_happened": event.StartTime.Unix(),
+		},
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestCompatHandlersRespondWithStringIDs(t *testing.T) {
+	s := newTestServer(t)
+
+	w := s.serve(t, http.MethodPost, "/api/v0/compat/grafana/api/annotations", map[string]interface{}{
+		"text": "tried a new cache",
+		"tags": []string{"experiment", "service:api"},
+	}, nil)
+	expectStatus(t, w, http.StatusOK)
+	grafana := struct {
+		ID interface{} `json:"id"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &grafana); err != nil {
+		t.Fatal(err)
+	}
+
+	w = s.serve(t, http.MethodPost, "/api/v0/compat/datadog/api/v1/events", map[string]interface{}{
+		"title": "restarted api",
+		"tags":  []string{"ops_activity"},
+	}, nil)
+	expectStatus(t, w, http.StatusAccepted)
+	datadog := struct {
+		Event struct {
+			ID interface{} `json:"id"`
+		} `json:"event"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &datadog); err != nil {
+		t.Fatal(err)
+	}
+
+	events := storedEvents(t, s)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	for i, id := range []interface{}{grafana.ID, datadog.Event.ID} {
+		if want := strconv.FormatInt(events[i].ID, 10); id != want {
+			t.Errorf("got ID %#v, want the string %q", id, want)
+		}
+	}
+}
+
+func TestCompatHandlersRecordDeploymentsWithoutSchema(t *testing.T) {
+	s := newTestServer(t)
+
+	w := s.serve(t, http.MethodPost, "/api/v0/compat/datadog/api/v1/events", map[string]interface{}{
+		"title": "deployed api",
+		"tags":  []string{"deployment", "type:WEBSRV", "machines:web1"},
+	}, nil)
+	expectStatus(t, w, http.StatusAccepted)
+
+	events := storedEvents(t, s)
+	if len(events) != 1 || events[0].EventType != eventTypeDeployment {
+		t.Fatalf("got %+v, want one DEPLOYMENT", events)
+	} else if metadata := metadataOf(t, events[0]); metadata["machines"] != "web1" {
+		t.Errorf("got metadata %v, want the tags", metadata)
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configFlag = "config"
+	configEnv  = "CONFIG"
+
+	// secretFileSuffix marks environment variables holding the path of a file to
+	// read a value from, such as a mounted Docker or Kubernetes secret.
+	secretFileSuffix = "_FILE"
+)
+
+// Config is the effective configuration. Each field is set, from lowest to
+// highest precedence, by its default, the YAML config file, its environment
+// variable and its flag. The YAML key is the flag name with underscores, and the
+// environment variable is the YAML key upper cased, e.g. --db-host, db_host and
+// DB_HOST.
+type Config struct {
+	Domain             string `yaml:"domain"`
+	UseAutocert        bool   `yaml:"use_autocert"`
+	Store              string `yaml:"store"`
+	SQLitePath         string `yaml:"sqlite_path"`
+	DBHost             string `yaml:"db_host"`
+	DBPort             int    `yaml:"db_port"`
+	DBUser             string `yaml:"db_user"`
+	DBPassword         string `yaml:"db_password"`
+	DBName             string `yaml:"db_name"`
+	HTTPPort           int    `yaml:"http_port"`
+	HTTPSPort          int    `yaml:"https_port"`
+	GitHubSecret       string `yaml:"github_secret"`
+	SlackSigningSecret string `yaml:"slack_signing_secret"`
+	SlackOAuthToken    string `yaml:"slack_oauth_token"`
+	SlackLogChannel    string `yaml:"slack_log_channel"`
+	OTLPEndpoint       string `yaml:"otlp_endpoint"`
+	RequireAPIKey      bool   `yaml:"require_api_key"`
+	NodeID             int64  `yaml:"node_id"`
+	TimeZone           string `yaml:"time_zone"`
+	LogLevel           string `yaml:"log_level"`
+	// GitHubDeployWorkflows and GitHubOpsWorkflows are comma separated names of
+	// GitHub Actions workflows whose runs are recorded.
+	GitHubDeployWorkflows string `yaml:"github_deploy_workflows"`
+	GitHubOpsWorkflows    string `yaml:"github_ops_workflows"`
+	// GitHubRepositorySecrets overrides GitHubSecret for some repositories or
+	// organizations.
+	GitHubRepositorySecrets repositorySecrets `yaml:"github_repository_secrets"`
+	GitHubAllowSHA1         bool              `yaml:"github_allow_sha1"`
+	// GitHubRoutingRules is the path of a YAML file of RoutingRules.
+	GitHubRoutingRules string `yaml:"github_routing_rules"`
+	// GitLabToken is a comma separated list of GitLab webhook secret tokens.
+	GitLabToken           string `yaml:"gitlab_token"`
+	GitLabDeployPipelines string `yaml:"gitlab_deploy_pipelines"`
+	GitLabOpsPipelines    string `yaml:"gitlab_ops_pipelines"`
+	// BitbucketSecret is a comma separated list of Bitbucket webhook secrets.
+	BitbucketSecret   string `yaml:"bitbucket_secret"`
+	BitbucketBranches string `yaml:"bitbucket_branches"`
+	// MetricsServices is a comma separated list of the services that are
+	// labelled in event_tracker_last_event_timestamp_seconds.
+	MetricsServices string `yaml:"metrics_services"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Domain:             "www.makeshift.dev",
+		Store:              storeMySQL,
+		SQLitePath:         "event-tracker.db",
+		DBHost:             "db",
+		DBPort:             3306,
+		DBUser:             "user",
+		DBPassword:         "password",
+		DBName:             "test",
+		HTTPPort:           80,
+		HTTPSPort:          443,
+		GitHubSecret:       "secret",
+		SlackSigningSecret: "secret",
+		SlackOAuthToken:    "secret",
+		SlackLogChannel:    "channel",
+		RequireAPIKey:      true,
+		BitbucketBranches:  "main,master",
+		NodeID:             -1,
+		TimeZone:           "America/New_York",
+		LogLevel:           "info",
+	}
+}
+
+// flags binds a flag to every field of c.
+func (c *Config) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.String(configFlag, "", fmt.Sprintf("path of a YAML config file (env %s)", configEnv))
+	fs.StringVar(&c.Domain, "domain", c.Domain, "domain for which a certificate should be obtained")
+	fs.BoolVar(&c.UseAutocert, "use-autocert", c.UseAutocert, "serve HTTPS using autocert")
+	fs.StringVar(&c.Store, "store", c.Store, "where to store events: \"mysql\", \"sqlite\" or \"memory\"")
+	fs.StringVar(&c.SQLitePath, "sqlite-path", c.SQLitePath, "path of the database file when using the sqlite store")
+	fs.StringVar(&c.DBHost, "db-host", c.DBHost, "database host name")
+	fs.IntVar(&c.DBPort, "db-port", c.DBPort, "database port number")
+	fs.StringVar(&c.DBUser, "db-user", c.DBUser, "username for database access")
+	fs.StringVar(&c.DBPassword, "db-password", c.DBPassword, "password for database access")
+	fs.StringVar(&c.DBName, "db-name", c.DBName, "name of database")
+	fs.IntVar(&c.HTTPPort, "http-port", c.HTTPPort, "port on which HTTP should be served")
+	fs.IntVar(&c.HTTPSPort, "https-port", c.HTTPSPort, "port on which HTTPS should be served")
+	fs.StringVar(&c.GitHubSecret, "github-secret", c.GitHubSecret, "comma separated github webhook secrets; any of them is accepted, so that a new secret can be added before the old one is removed")
+	fs.StringVar(&c.SlackSigningSecret, "slack-signing-secret", c.SlackSigningSecret, "slack signing secret")
+	fs.StringVar(&c.SlackOAuthToken, "slack-oauth-token", c.SlackOAuthToken, "slack oauth token")
+	fs.StringVar(&c.SlackLogChannel, "slack-log-channel", c.SlackLogChannel, "slack log channel")
+	fs.StringVar(&c.OTLPEndpoint, "otlp-endpoint", c.OTLPEndpoint, "base URL of an OTLP/HTTP collector to export traces to, e.g. \"http://localhost:4318\" (default no export)")
+	fs.BoolVar(&c.RequireAPIKey, "require-api-key", c.RequireAPIKey, "require an API key for /api/v0 other than the GitHub, GitLab, Bitbucket and Slack webhooks; only turn this off for local development")
+	fs.Int64Var(&c.NodeID, "node-id", c.NodeID, fmt.Sprintf("unique ID of this replica between 0 and %d, used when generating event IDs (default derived from the host name)", maxNodeID))
+	fs.StringVar(&c.TimeZone, "time-zone", c.TimeZone, "time zone to use when logging to various sources")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "minimum level of log messages: \"debug\", \"info\", \"warn\" or \"error\"; debug also logs Slack request bodies with secrets redacted")
+	fs.StringVar(&c.GitHubDeployWorkflows, "github-deploy-workflows", c.GitHubDeployWorkflows, "comma separated names of GitHub Actions workflows whose runs are recorded as DEPLOYMENT events")
+	fs.StringVar(&c.GitHubOpsWorkflows, "github-ops-workflows", c.GitHubOpsWorkflows, "comma separated names of GitHub Actions workflows whose runs are recorded as OPS ACTIVITY events")
+	fs.Var(&c.GitHubRepositorySecrets, "github-repository-secrets", "github webhook secrets for some repositories or organizations instead of github-secret, e.g. \"octo/app=secret1,secret2;octo-org=secret3\"")
+	fs.BoolVar(&c.GitHubAllowSHA1, "github-allow-sha1", c.GitHubAllowSHA1, "also accept github webhooks signed only with the legacy X-Hub-Signature SHA-1 header, e.g. from old GitHub Enterprise Server versions; SHA-1 signatures are weaker, so leave this off unless needed")
+	fs.StringVar(&c.GitHubRoutingRules, "github-routing-rules", c.GitHubRoutingRules, "path of a YAML file of rules deciding how github webhooks are recorded per repository and ref; it is reloaded when it changes (default record as built in)")
+	fs.StringVar(&c.GitLabToken, "gitlab-token", c.GitLabToken, "comma separated gitlab webhook secret tokens; any of them is accepted (default reject every gitlab webhook)")
+	fs.StringVar(&c.GitLabDeployPipelines, "gitlab-deploy-pipelines", c.GitLabDeployPipelines, "comma separated names of GitLab pipelines whose runs are recorded as DEPLOYMENT events; a pipeline without a name is matched by \"<project>@<ref>\" or by its source, e.g. \"schedule\"")
+	fs.StringVar(&c.GitLabOpsPipelines, "gitlab-ops-pipelines", c.GitLabOpsPipelines, "comma separated names of GitLab pipelines whose runs are recorded as OPS ACTIVITY events; a pipeline without a name is matched as for gitlab-deploy-pipelines")
+	fs.StringVar(&c.BitbucketSecret, "bitbucket-secret", c.BitbucketSecret, "comma separated bitbucket webhook secrets; any of them is accepted (default reject every bitbucket webhook)")
+	fs.StringVar(&c.BitbucketBranches, "bitbucket-branches", c.BitbucketBranches, "comma separated branches whose bitbucket pushes are recorded, as bitbucket webhooks do not name the default branch")
+	fs.StringVar(&c.MetricsServices, "metrics-services", c.MetricsServices, "comma separated metadata services that get their own last event timestamp metric; events of other services share the one with an empty service (default none)")
+	return fs
+}
+
+// envName is the environment variable for a flag.
+func envName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadConfig layers the config file, environment and flags in args over the
+// defaults. It returns the arguments left after the flags.
+func loadConfig(args []string, environ func(string) (string, bool)) (*Config, []string, error) {
+	// Parse the flags once to find the config file and which flags were set, then
+	// apply them again last so that they take precedence.
+	c := defaultConfig()
+	fs := c.flags()
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] [migrate up|down|status | api-keys create|list|revoke | config print]\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	set := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	*c = *defaultConfig()
+
+	path, ok := set[configFlag]
+	if !ok {
+		path, _ = environ(configEnv)
+	}
+	if len(path) > 0 {
+		if err := c.readFile(path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == configFlag {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := environ(name)
+		if file, fileOK := environ(name + secretFileSuffix); fileOK {
+			if ok {
+				err = fmt.Errorf("only one of %s and %s may be set", name, name+secretFileSuffix)
+				return
+			}
+			var b []byte
+			if b, err = ioutil.ReadFile(file); err != nil {
+				err = fmt.Errorf("%s: %w", name+secretFileSuffix, err)
+				return
+			}
+			value, ok = strings.TrimRight(string(b), "\r\n"), true
+		}
+		if ok {
+			if setErr := f.Value.Set(value); setErr != nil {
+				if isSecretField(f.Name) {
+					value = redacted
+				}
+				err = fmt.Errorf("invalid value %q for %s: %w", value, name, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name, value := range set {
+		fs.Set(name, value)
+	}
+
+	return c, fs.Args(), nil
+}
+
+// readFile reads a YAML config file. Unknown keys are rejected so that typos do
+// not go unnoticed.
+func (c *Config) readFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Validate checks the effective configuration before anything is started.
+func (c *Config) Validate() error {
+	switch c.Store {
+	case storeMySQL:
+		if len(c.DBHost) == 0 || len(c.DBName) == 0 {
+			return fmt.Errorf("db-host and db-name are required when store is \"%s\"", storeMySQL)
+		} else if c.DBPort < 1 || c.DBPort > 65535 {
+			return fmt.Errorf("db-port must be between 1 and 65535")
+		}
+	case storeSQLite:
+		if len(c.SQLitePath) == 0 {
+			return fmt.Errorf("sqlite-path is required when store is \"%s\"", storeSQLite)
+		}
+	case storeMemory:
+	default:
+		return fmt.Errorf("store must be \"%s\", \"%s\" or \"%s\"", storeMySQL, storeSQLite, storeMemory)
+	}
+
+	if c.HTTPPort < 1 || c.HTTPPort > 65535 {
+		return fmt.Errorf("http-port must be between 1 and 65535")
+	} else if c.HTTPSPort < 1 || c.HTTPSPort > 65535 {
+		return fmt.Errorf("https-port must be between 1 and 65535")
+	} else if c.UseAutocert && len(c.Domain) == 0 {
+		return fmt.Errorf("domain is required when use-autocert is true")
+	} else if c.NodeID > maxNodeID {
+		return fmt.Errorf("node-id must be between 0 and %d", maxNodeID)
+	}
+
+	if len(c.OTLPEndpoint) > 0 {
+		u, err := url.Parse(c.OTLPEndpoint)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || len(u.Host) == 0 {
+			return fmt.Errorf("otlp-endpoint must be an http or https URL")
+		}
+	}
+
+	if len(splitList(c.GitHubSecret)) == 0 {
+		return fmt.Errorf("github-secret is required")
+	}
+	for name, secrets := range c.GitHubRepositorySecrets {
+		if len(secrets) == 0 {
+			return fmt.Errorf("github-repository-secrets: \"%s\" has no secrets", name)
+		}
+	}
+
+	if len(c.GitHubRoutingRules) > 0 {
+		if _, err := readRoutingRules(c.GitHubRoutingRules); err != nil {
+			return fmt.Errorf("github-routing-rules: %w", err)
+		}
+	}
+
+	if name, ok := workflowInBoth(c.GitHubDeployWorkflows, c.GitHubOpsWorkflows); ok {
+		return fmt.Errorf("workflow \"%s\" is in both github-deploy-workflows and github-ops-workflows", name)
+	} else if name, ok := workflowInBoth(c.GitLabDeployPipelines, c.GitLabOpsPipelines); ok {
+		return fmt.Errorf("pipeline \"%s\" is in both gitlab-deploy-pipelines and gitlab-ops-pipelines", name)
+	}
+
+	if _, err := time.LoadLocation(c.TimeZone); err != nil {
+		return fmt.Errorf("time-zone: %w", err)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return fmt.Errorf("log-level must be \"debug\", \"info\", \"warn\" or \"error\"")
+	}
+
+	return nil
+}
+
+// GitHubWorkflows maps the names of the GitHub Actions workflows whose runs are
+// recorded to the type of event they are recorded as.
+func (c *Config) GitHubWorkflows() map[string]string {
+	return workflowEventTypes(c.GitHubDeployWorkflows, c.GitHubOpsWorkflows)
+}
+
+// GitLabPipelines is GitHubWorkflows for GitLab pipelines.
+func (c *Config) GitLabPipelines() map[string]string {
+	return workflowEventTypes(c.GitLabDeployPipelines, c.GitLabOpsPipelines)
+}
+
+// workflowEventTypes maps the names in the comma separated lists of deploy and
+// ops workflows to DEPLOYMENT and OPS ACTIVITY.
+func workflowEventTypes(deploy, ops string) map[string]string {
+	workflows := map[string]string{}
+	for _, name := range splitList(deploy) {
+		workflows[name] = eventTypeDeployment
+	}
+	for _, name := range splitList(ops) {
+		workflows[name] = eventTypeOpsActivity
+	}
+	return workflows
+}
+
+// workflowInBoth returns a workflow that is in both lists, if any.
+func workflowInBoth(deploy, ops string) (string, bool) {
+	deployWorkflows := map[string]bool{}
+	for _, name := range splitList(deploy) {
+		deployWorkflows[name] = true
+	}
+	for _, name := range splitList(ops) {
+		if deployWorkflows[name] {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Redacted returns a copy of the configuration that is safe to print.
+func (c *Config) Redacted() *Config {
+	redactedConfig := *c
+	for _, secret := range []*string{
+		&redactedConfig.DBPassword,
+		&redactedConfig.GitHubSecret,
+		&redactedConfig.SlackSigningSecret,
+		&redactedConfig.SlackOAuthToken,
+		&redactedConfig.GitLabToken,
+		&redactedConfig.BitbucketSecret,
+	} {
+		if len(*secret) > 0 {
+			*secret = redacted
+		}
+	}
+	if c.GitHubRepositorySecrets != nil {
+		redactedConfig.GitHubRepositorySecrets = repositorySecrets{}
+		for name := range c.GitHubRepositorySecrets {
+			redactedConfig.GitHubRepositorySecrets[name] = []string{redacted}
+		}
+	}
+	return &redactedConfig
+}
+
+// Print implements the "config print" subcommand, which writes the effective
+// configuration as YAML with secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// repositorySecrets maps repositories or organizations to their secrets. As a
+// flag or environment variable it is written "name=secret,secret;name=secret",
+// and in the config file as a map of names to a secret or a list of secrets.
+type repositorySecrets map[string][]string
+
+func (r *repositorySecrets) String() string {
+	if r == nil {
+		return ""
+	}
+	names := make([]string, 0, len(*r))
+	for name := range *r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]string, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, name+"="+strings.Join((*r)[name], ","))
+	}
+	return strings.Join(entries, ";")
+}
+
+func (r *repositorySecrets) Set(value string) error {
+	secrets := repositorySecrets{}
+	for _, entry := range strings.Split(value, ";") {
+		if entry = strings.TrimSpace(entry); len(entry) == 0 {
+			continue
+		}
+		i := strings.Index(entry, "=")
+		if i < 1 {
+			// The entry is not echoed, since it may be a secret.
+			return fmt.Errorf("entries must be name=secret[,secret]")
+		}
+		secrets[strings.TrimSpace(entry[:i])] = splitList(entry[i+1:])
+	}
+	*r = secrets
+	return nil
+}
+
+func (r *repositorySecrets) UnmarshalYAML(value *yaml.Node) error {
+	nodes := map[string]yaml.Node{}
+	if err := value.Decode(&nodes); err != nil {
+		return err
+	}
+
+	secrets := repositorySecrets{}
+	for name, node := range nodes {
+		if node.Kind == yaml.ScalarNode {
+			secrets[name] = []string{node.Value}
+			continue
+		}
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		secrets[name] = list
+	}
+	*r = secrets
+	return nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// terminalDeploymentStates are the GitHub deployment states that end a
+// deployment.
+var terminalDeploymentStates = map[string]bool{
+	"success": true,
+	"failure": true,
+	"error":   true,
+}
+
+// GitHubDeployment is the deployment object of deployment and
+// deployment_status webhooks.
+type GitHubDeployment struct {
+	ID          int64           `json:"id"`
+	URL         string          `json:"url"`
+	SHA         string          `json:"sha"`
+	Ref         string          `json:"ref"`
+	Task        string          `json:"task"`
+	Environment string          `json:"environment"`
+	Description string          `json:"description"`
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Creator     struct {
+		Login string `json:"login"`
+	} `json:"creator"`
+}
+
+type DeploymentData struct {
+	GitHubRepositoryData
+	Action     string           `json:"action"`
+	Deployment GitHubDeployment `json:"deployment"`
+}
+
+type DeploymentStatusData struct {
+	GitHubRepositoryData
+	Action           string `json:"action"`
+	DeploymentStatus struct {
+		State       string    `json:"state"`
+		Description string    `json:"description"`
+		TargetURL   string    `json:"target_url"`
+		LogURL      string    `json:"log_url"`
+		CreatedAt   time.Time `json:"created_at"`
+	} `json:"deployment_status"`
+	Deployment GitHubDeployment `json:"deployment"`
+}
+
+// errDeploymentStateRecorded is returned by the patch of updateDeployment when
+// the deployment already has the state, or has finished.
+var errDeploymentStateRecorded = errors.New("the deployment already has the state or has finished")
+
+// deploymentIdempotencyKey ties the DEPLOYMENT event to the GitHub deployment,
+// so that its statuses can find it.
+func deploymentIdempotencyKey(repository string, id int64) string {
+	return "github-deployment:" + repository + ":" + strconv.FormatInt(id, 10)
+}
+
+// deploymentRefs are the refs that a deployment's ref can stand for. GitHub
+// gives it as it was passed when the deployment was created, which may be a
+// full ref, a bare branch or tag name, or a SHA.
+func deploymentRefs(ref string) []string {
+	if strings.HasPrefix(ref, "refs/") {
+		return []string{ref}
+	}
+	return []string{"refs/heads/" + ref, "refs/tags/" + ref, ref}
+}
+
+// newDeploymentEvent is the DEPLOYMENT event for a GitHub deployment. Fields in
+// the deployment's payload, which is set by whoever created the deployment, are
+// copied into the metadata, so that a deployment can name e.g. its service.
+func newDeploymentEvent(repository string, deployment *GitHubDeployment, state string) *Event {
+	metadata := map[string]interface{}{}
+	if len(deployment.Payload) > 0 {
+		json.Unmarshal(deployment.Payload, &metadata)
+	}
+
+	metadata["github_event"] = deploymentEvent
+	metadata["repository"] = repository
+	metadata["deployment_id"] = deployment.ID
+	metadata["environment"] = deployment.Environment
+	metadata["ref"] = deployment.Ref
+	metadata["sha"] = deployment.SHA
+	metadata["task"] = deployment.Task
+	metadata["creator"] = deployment.Creator.Login
+	metadata["state"] = state
+
+	notes := deployment.Description
+	if len(notes) == 0 {
+		notes = fmt.Sprintf("Deploy %s@%s to %s", repository, deployment.Ref, deployment.Environment)
+	}
+
+	return &Event{
+		EventType:       eventTypeDeployment,
+		StartTime:       deployment.CreatedAt,
+		Notes:           notes,
+		Metadata:        metadata,
+		IdempotencyKey:  deploymentIdempotencyKey(repository, deployment.ID),
+		fromIntegration: true,
+	}
+}
+
+// DeploymentHandler opens a DEPLOYMENT event when a GitHub deployment is
+// created. DeploymentStatusHandler closes it.
+func (s *server) DeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	request := DeploymentData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if request.Action != "created" {
+		respondWithJSON(w, http.StatusOK, nil, "", request)
+		return
+	}
+
+	event := newDeploymentEvent(request.Repository.FullName, &request.Deployment, "created")
+	if !s.route(event, request.Repository.FullName, deploymentRefs(request.Deployment.Ref)...) {
+		respondWithJSON(w, http.StatusOK, nil, "", request)
+		return
+	} else if _, ok := s.eventTypes.Lookup(event.EventType); !ok {
+		respondWithJSON(w, http.StatusOK, nil, fmt.Sprintf("event type \"%s\" is not registered", event.EventType), request)
+		return
+	}
+
+	s.recordDeployment(w, r, event)
+}
+
+// DeploymentStatusHandler records the state of a GitHub deployment on its
+// DEPLOYMENT event, and ends the event once the deployment has finished.
+func (s *server) DeploymentStatusHandler(w http.ResponseWriter, r *http.Request) {
+	request := DeploymentStatusData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	status := request.DeploymentStatus
+	statusURL := status.LogURL
+	if len(statusURL) == 0 {
+		statusURL = status.TargetURL
+	}
+
+	statusMetadata := map[string]interface{}{
+		"state":              status.State,
+		"status_description": status.Description,
+		"status_url":         statusURL,
+	}
+
+	// The event is only recorded from the status if the deployment webhook was
+	// missed, but the routing rules apply either way.
+	event := newDeploymentEvent(request.Repository.FullName, &request.Deployment, status.State)
+	if !s.route(event, request.Repository.FullName, deploymentRefs(request.Deployment.Ref)...) {
+		respondWithJSON(w, http.StatusOK, nil, "", request)
+		return
+	} else if _, ok := s.eventTypes.Lookup(event.EventType); !ok {
+		respondWithJSON(w, http.StatusOK, nil, fmt.Sprintf("event type \"%s\" is not registered", event.EventType), request)
+		return
+	}
+
+	// If the deployment webhook was missed, the deployment is recorded from the
+	// status instead.
+	for name, value := range statusMetadata {
+		event.Metadata.(map[string]interface{})[name] = value
+	}
+	s.updateDeployment(w, r, event, statusMetadata, status.CreatedAt, terminalDeploymentStates)
+}
+
+// updateDeployment records the state in metadata, which includes at least
+// "state", on the event of a deployment that was recorded with the event's
+// idempotency key, or records the event if the deployment was not recorded yet.
+// A state in terminalStates ends the event at endTime and is posted to Slack.
+// Statuses can be delivered out of order, so none is recorded after that.
+func (s *server) updateDeployment(w http.ResponseWriter, r *http.Request, event *Event, metadata map[string]interface{}, endTime time.Time, terminalStates map[string]bool) {
+	state, _ := metadata["state"].(string)
+	terminal := terminalStates[state]
+
+	existing, err := s.store.GetByIdempotencyKey(r.Context(), event.IdempotencyKey)
+	if errors.Is(err, errEventNotFound) {
+		if terminal && endTime.After(event.StartTime) {
+			event.EndTime.Time = endTime
+			event.EndTime.Valid = true
+		}
+		s.recordDeployment(w, r, event)
+		return
+	} else if err != nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to read from database",
+			nil,
+		)
+		return
+	}
+
+	// Redeliveries of a state change nothing, and neither does anything after
+	// the deployment has finished. The state is checked within the update, so
+	// that concurrent deliveries cannot both pass the check. Like the event
+	// itself, the metadata is not held to the DEPLOYMENT schema.
+	patch := &EventPatch{Metadata: metadata}
+	patch.precondition = func(existing *Event) error {
+		existingMetadata := map[string]interface{}{}
+		if raw, ok := existing.Metadata.(json.RawMessage); ok {
+			json.Unmarshal(raw, &existingMetadata)
+		}
+		existingState, _ := existingMetadata["state"].(string)
+		if existingState == state || terminalStates[existingState] {
+			return errDeploymentStateRecorded
+		}
+		return nil
+	}
+	if terminal && endTime.After(existing.StartTime) {
+		patch.EndTime = &NullTime{}
+		patch.EndTime.Time = endTime
+		patch.EndTime.Valid = true
+	}
+
+	updated, err := s.store.Update(r.Context(), existing.ID, patch)
+	if errors.Is(err, errDeploymentStateRecorded) {
+		respondWithJSON(w, http.StatusOK, nil, "", existing)
+		return
+	} else if errors.Is(err, errEventNotFound) {
+		respondWithJSON(w, http.StatusOK, nil, "the deployment's event was deleted", nil)
+		return
+	} else if err != nil {
+		respondWithWriteError(w, err)
+		return
+	}
+
+	if terminal {
+		// The event is already updated, so a failure to post is only logged.
+		updated.slackChannel = event.slackChannel
+		s.logToSlackChannel(r.Context(), updated)
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", updated)
+}
+
+// recordDeployment records a DEPLOYMENT event from a webhook.
+func (s *server) recordDeployment(w http.ResponseWriter, r *http.Request, event *Event) {
+	if err := s.writeToDBAndLog(r.Context(), event); err != nil {
+		respondWithWriteError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", event)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testGitHubDeployment(id int64) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          id,
+		"sha":         "a10867b14bb761a232cd80139fbd4c0d33264240",
+		"ref":         "main",
+		"task":        "deploy",
+		"environment": "production",
+		"created_at":  "2024-03-01T12:00:00Z",
+		"creator":     map[string]interface{}{"login": "octocat"},
+	}
+}
+
+func testGitHubDeploymentStatus(id int64, state, createdAt string) map[string]interface{} {
+	return map[string]interface{}{
+		"action":     "created",
+		"repository": map[string]interface{}{"full_name": "octo/app"},
+		"deployment": testGitHubDeployment(id),
+		"deployment_status": map[string]interface{}{
+			"state":       state,
+			"description": "Deployment " + state,
+			"log_url":     "https://github.com/octo/app/actions/runs/1",
+			"created_at":  createdAt,
+		},
+	}
+}
+
+func TestDeploymentHandlers(t *testing.T) {
+	s := newTestServer(t)
+	slack := useFakeSlack(t, s, "#deploys")
+
+	// The deployment has no type or machines, which the DEPLOYMENT schema
+	// requires of events recorded through the API.
+	expectStatus(t, s.serveGitHub(t, deploymentEvent, "d1", map[string]interface{}{
+		"action":     "created",
+		"repository": map[string]interface{}{"full_name": "octo/app"},
+		"deployment": testGitHubDeployment(42),
+	}), http.StatusOK)
+
+	for i, status := range []struct {
+		state, createdAt string
+	}{
+		{"in_progress", "2024-03-01T12:01:00Z"},
+		{"success", "2024-03-01T12:03:00Z"},
+		// Redeliveries and statuses after the deployment finished are ignored.
+		{"success", "2024-03-01T12:03:00Z"},
+		{"inactive", "2024-03-02T09:00:00Z"},
+	} {
+		expectStatus(t, s.serveGitHub(t, deploymentStatusEvent, "s"+strconv.Itoa(i), testGitHubDeploymentStatus(42, status.state, status.createdAt)), http.StatusOK)
+	}
+
+	events := storedEvents(t, s)
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	event := events[0]
+	if metadata := metadataOf(t, event); metadata["state"] != "success" || metadata["deployment_id"] != float64(42) {
+		t.Errorf("got metadata %v, want the successful deployment", metadata)
+	}
+	if !event.EndTime.Valid || event.EndTime.Time.Format("15:04") != "12:03" {
+		t.Errorf("got end time %v, want the success status's", event.EndTime)
+	}
+
+	posted := slack.posted()
+	if len(posted) != 2 || !strings.Contains(posted[1], "Deployment of octo/app@main (a10867b) to production by octocat: success") {
+		t.Errorf("posted %q, want the deployment and its success", posted)
+	}
+}
+
+// barrierStore holds each GetByIdempotencyKey until every expected call has
+// been made, so that concurrent requests all read before any of them writes.
+type barrierStore struct {
+	EventStore
+	reads sync.WaitGroup
+}
+
+func (s *barrierStore) GetByIdempotencyKey(ctx context.Context, key string) (*Event, error) {
+	event, err := s.EventStore.GetByIdempotencyKey(ctx, key)
+	s.reads.Done()
+	s.reads.Wait()
+	return event, err
+}
+
+func TestDeploymentStatusHandlerFinishesOnce(t *testing.T) {
+	s := newTestServer(t)
+	slack := useFakeSlack(t, s, "#deploys")
+
+	expectStatus(t, s.serveGitHub(t, deploymentEvent, "d1", map[string]interface{}{
+		"action":     "created",
+		"repository": map[string]interface{}{"full_name": "octo/app"},
+		"deployment": testGitHubDeployment(42),
+	}), http.StatusOK)
+
+	// Concurrent statuses that each finish the deployment end it only once.
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	store := &barrierStore{EventStore: s.store}
+	store.reads.Add(len(codes))
+	s.store = store
+	for i := range codes {
+		state := "success"
+		if i%2 == 1 {
+			state = "failure"
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = s.serveGitHub(t, deploymentStatusEvent, "s"+strconv.Itoa(i), testGitHubDeploymentStatus(42, state, "2024-03-01T12:03:00Z")).Code
+		}(i)
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("status %d got %d, want %d", i, code, http.StatusOK)
+		}
+	}
+	if posted := slack.posted(); len(posted) != 2 {
+		t.Errorf("posted %q, want the deployment and one finished state", posted)
+	}
+}
+
+func TestDeploymentStatusHandlerRecordsMissedDeployments(t *testing.T) {
+	s := newTestServer(t)
+
+	expectStatus(t, s.serveGitHub(t, deploymentStatusEvent, "s1", testGitHubDeploymentStatus(7, "failure", "2024-03-01T12:05:00Z")), http.StatusOK)
+
+	events := storedEvents(t, s)
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	} else if metadata := metadataOf(t, events[0]); metadata["state"] != "failure" || metadata["status_description"] != "Deployment failure" {
+		t.Errorf("got metadata %v, want the failed status", metadata)
+	} else if !events[0].EndTime.Valid {
+		t.Error("the failed deployment has no end time")
+	}
+}
@@ -1,44 +1,38 @@
 package main
 
-import (
-	"fmt"
-)
-
+// DeploymentMetadata is the metadata expected for DEPLOYMENT events.
 type DeploymentMetadata struct {
 	Type     string   `json:"type"`
 	Service  string   `json:"service"`
 	Machines []string `json:"machines"`
 }
 
-func (m *DeploymentMetadata) Validate() error {
-	isValidType := map[string]bool{
-		"OKCONTENT": true,
-		"WEBSRV":    true,
-		"RPCSRV":    true,
-		"DBPROX":    true,
-		"OKAPI":     true,
-		"GRPC":      true,
-		"CONF":      true,
-	}
-	if !isValidType[m.Type] {
-		return fmt.Errorf("invalid deployment type \"%s\"", m.Type)
-	}
-
-	requiresService := map[string]bool{
-		"RPCSRV": true,
-		"DBPROX": true,
-		"GRPC":   true,
-	}
-	if requiresService[m.Type] && len(m.Service) == 0 {
-		return fmt.Errorf(
-			"deployment type \"%s\" requires non-empty \"service\" field",
-			m.Type,
-		)
+// deploymentMetadataSchema is the JSON Schema registered for DEPLOYMENT events.
+// The type must be one of the known deployment types, RPCSRV, DBPROX and GRPC
+// deployments require a non-empty service, and every deployment must list the
+// machines it went to. The event_types migration that adds metadata schemas
+// inserts the same schema.
+const deploymentMetadataSchema = `{
+	"type": "object",
+	"properties": {
+		"type": {
+			"enum": ["OKCONTENT", "WEBSRV", "RPCSRV", "DBPROX", "OKAPI", "GRPC", "CONF"]
+		},
+		"service": {
+			"type": "string"
+		},
+		"machines": {
+			"type": "array",
+			"items": {"type": "string"},
+			"minItems": 1
+		}
+	},
+	"required": ["type", "machines"],
+	"if": {
+		"properties": {"type": {"enum": ["RPCSRV", "DBPROX", "GRPC"]}}
+	},
+	"then": {
+		"properties": {"service": {"minLength": 1}},
+		"required": ["service"]
 	}
-
-	if len(m.Machines) == 0 {
-		return fmt.Errorf("must specify \"machines\" list for a %s deployment", m.Type)
-	}
-
-	return nil
-}
+}`
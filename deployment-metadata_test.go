@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestEventTypes returns a registry of the default event types.
+func newTestEventTypes(t *testing.T) *EventTypeRegistry {
+	t.Helper()
+
+	eventTypes := NewEventTypeRegistry(NewMemoryEventStore())
+	if err := eventTypes.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return eventTypes
+}
+
+func TestDeploymentMetadataSchema(t *testing.T) {
+	eventType, ok := newTestEventTypes(t).Lookup(eventTypeDeployment)
+	if !ok {
+		t.Fatal("DEPLOYMENT is not registered")
+	}
+
+	for _, test := range []struct {
+		name     string
+		metadata interface{}
+		valid    bool
+	}{
+		{"struct", &DeploymentMetadata{Type: "WEBSRV", Machines: []string{"web1"}}, true},
+		{"service", map[string]interface{}{"type": "RPCSRV", "service": "api", "machines": []string{"rpc1"}}, true},
+		{"no metadata", nil, false},
+		{"no machines", map[string]interface{}{"type": "WEBSRV", "machines": []string{}}, false},
+		{"unknown type", map[string]interface{}{"type": "LAMBDA", "machines": []string{"web1"}}, false},
+		{"no service", map[string]interface{}{"type": "GRPC", "machines": []string{"grpc1"}}, false},
+		{"machines as a string", map[string]interface{}{"type": "WEBSRV", "machines": "web1"}, false},
+	} {
+		err := validateMetadata(eventTypeDeployment, eventType.schema, test.metadata)
+		var metadataErr *MetadataError
+		if test.valid && err != nil {
+			t.Errorf("%s: got %v, want valid", test.name, err)
+		} else if !test.valid && !errors.As(err, &metadataErr) {
+			t.Errorf("%s: got %v, want a *MetadataError", test.name, err)
+		}
+	}
+}
+
+// TestIntegrationDeploymentMetadata runs the DEPLOYMENT metadata of every
+// integration through the schema. None of them can match it, which is why
+// integrations are not held to it.
+func TestIntegrationDeploymentMetadata(t *testing.T) {
+	eventTypes := newTestEventTypes(t)
+	eventType, _ := eventTypes.Lookup(eventTypeDeployment)
+
+	for name, metadata := range map[string]interface{}{
+		// Compatible ingest turns tags into strings, so machines is never a list.
+		"compat": tagsToMetadata([]string{"type:WEBSRV", "machines:web1", "service:api"}),
+		"github deployment": newDeploymentEvent("octo/app", &GitHubDeployment{
+			ID:          1,
+			Ref:         "main",
+			Environment: "production",
+			CreatedAt:   time.Now(),
+		}, "pending").Metadata,
+		"github workflow run": &WorkflowRunMetadata{
+			GitHubEvent: workflowRunEvent,
+			Repository:  "octo/app",
+			Workflow:    "deploy",
+			RunID:       1,
+			Conclusion:  "success",
+		},
+		"gitlab deployment": &VCSMetadata{
+			VCS:         vcsGitLab,
+			VCSEvent:    "deployment",
+			Repository:  "group/project",
+			Environment: "production",
+			State:       "success",
+		},
+	} {
+		var metadataErr *MetadataError
+		if err := validateMetadata(eventTypeDeployment, eventType.schema, metadata); !errors.As(err, &metadataErr) {
+			t.Errorf("%s: got %v, want a *MetadataError", name, err)
+		}
+
+		event := &Event{EventType: eventTypeDeployment, Notes: "x", Metadata: metadata}
+		if err := event.ValidateAndRectify(eventTypes); err == nil {
+			t.Errorf("%s: recorded through the API, got no error", name)
+		}
+		event.fromIntegration = true
+		if err := event.ValidateAndRectify(eventTypes); err != nil {
+			t.Errorf("%s: recorded by the integration, got %v", name, err)
+		}
+	}
+}
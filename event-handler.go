@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// EventPatch is the request body for PATCH /api/v0/events/{id}. Omitted fields
+// are left unchanged and metadata keys are merged into the existing metadata.
+type EventPatch struct {
+	EndTime  *NullTime              `json:"end_time"`
+	Notes    *string                `json:"notes"`
+	Metadata map[string]interface{} `json:"metadata"`
+
+	// eventTypes is used to validate the merged metadata, if set, unless the
+	// event was recorded by an integration.
+	eventTypes *EventTypeRegistry
+	// precondition, if set, is checked against the stored event before the patch
+	// is applied, in the same transaction.
+	precondition func(event *Event) error
+}
+
+// Apply updates the event in place, enforcing the same rules as
+// Event.ValidateAndRectify.
+func (p *EventPatch) Apply(event *Event) error {
+	if p.precondition != nil {
+		if err := p.precondition(event); err != nil {
+			return err
+		}
+	}
+
+	if p.EndTime != nil && p.EndTime.Valid {
+		if !p.EndTime.Time.After(event.StartTime) {
+			return fmt.Errorf("end_time must be after start_time")
+		}
+		event.EndTime = *p.EndTime
+	}
+
+	if p.Notes != nil {
+		if len(*p.Notes) == 0 {
+			return fmt.Errorf("notes parameter cannot be empty")
+		}
+		event.Notes = *p.Notes
+	}
+
+	if len(p.Metadata) > 0 {
+		metadata := map[string]interface{}{}
+		if raw, ok := event.Metadata.(json.RawMessage); ok && len(raw) > 0 && string(raw) != "null" {
+			if err := json.Unmarshal(raw, &metadata); err != nil {
+				return fmt.Errorf("existing metadata is not an object and cannot be merged")
+			}
+		}
+		for key, value := range p.Metadata {
+			metadata[key] = value
+		}
+		event.Metadata = metadata
+
+		if p.eventTypes != nil && !event.fromIntegration {
+			if eventType, ok := p.eventTypes.Lookup(event.EventType); ok {
+				if err := eventType.ValidateMetadata(event.Metadata); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseEventID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid event id")
+	}
+	return id, nil
+}
+
+func (s *server) GetEventHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseEventID(r)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	event, err := s.store.Get(r.Context(), id)
+	if errors.Is(err, errEventNotFound) {
+		respondWithJSON(w, http.StatusNotFound, err, "", nil)
+		return
+	} else if err != nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to read from database",
+			nil,
+		)
+		return
+	}
+
+	if acceptsCloudEvents(r, applicationCloudEvents) {
+		respondWithCloudEvents(w, applicationCloudEvents, *event)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", event)
+}
+
+// authorizeStoredEvent checks that the API key may change the event, which
+// depends on its type. It responds with an error and returns false if not.
+func (s *server) authorizeStoredEvent(w http.ResponseWriter, r *http.Request, id int64) bool {
+	event, err := s.store.Get(r.Context(), id)
+	if errors.Is(err, errEventNotFound) {
+		respondWithJSON(w, http.StatusNotFound, err, "", nil)
+		return false
+	} else if err != nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to read from database",
+			nil,
+		)
+		return false
+	} else if err := authorizeEventType(r.Context(), event.EventType); err != nil {
+		respondWithJSON(w, http.StatusForbidden, err, "", nil)
+		return false
+	}
+
+	return true
+}
+
+func (s *server) PatchEventHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseEventID(r)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	patch := EventPatch{eventTypes: s.eventTypes}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if !s.authorizeStoredEvent(w, r, id) {
+		return
+	}
+
+	event, err := s.store.Update(r.Context(), id, &patch)
+	if errors.Is(err, errEventNotFound) {
+		respondWithJSON(w, http.StatusNotFound, err, "", nil)
+		return
+	} else if err != nil {
+		respondWithWriteError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", event)
+}
+
+func (s *server) DeleteEventHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseEventID(r)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if !s.authorizeStoredEvent(w, r, id) {
+		return
+	}
+
+	if err := s.store.Delete(r.Context(), id); errors.Is(err, errEventNotFound) {
+		respondWithJSON(w, http.StatusNotFound, err, "", nil)
+		return
+	} else if err != nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to write to database",
+			nil,
+		)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", nil)
+}
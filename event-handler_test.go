@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// createTestAPIKey stores a new API key with the scope and event types, and
+// returns the headers that authenticate with it.
+func createTestAPIKey(t *testing.T, s *server, scope string, eventTypes ...string) map[string]string {
+	t.Helper()
+
+	key := &APIKey{Name: "test", Scopes: []string{scope}, EventTypes: eventTypes}
+	if err := key.Validate(); err != nil {
+		t.Fatal(err)
+	} else if err := key.generate(); err != nil {
+		t.Fatal(err)
+	} else if err := s.store.CreateAPIKey(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]string{"Authorization": "Bearer " + key.Key}
+}
+
+func TestEventHandlersEnforceEventTypeAllowlist(t *testing.T) {
+	s := newTestServer(t)
+	*s.RequireAPIKey = true
+
+	event := &Event{ID: 1, EventType: eventTypeExperiment, Notes: "x", StartTime: time.Now()}
+	if err := s.store.Insert(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/v0/events/" + strconv.FormatInt(event.ID, 10)
+	patch := map[string]interface{}{"notes": "y"}
+
+	deployer := createTestAPIKey(t, s, scopeEventsWrite, eventTypeDeployment)
+	expectStatus(t, s.serve(t, http.MethodPatch, path, patch, deployer), http.StatusForbidden)
+	expectStatus(t, s.serve(t, http.MethodDelete, path, nil, deployer), http.StatusForbidden)
+	if got, err := s.store.Get(context.Background(), event.ID); err != nil || got.Notes != "x" {
+		t.Fatalf("got %+v, %v, want the event unchanged", got, err)
+	}
+
+	experimenter := createTestAPIKey(t, s, scopeEventsWrite, eventTypeExperiment)
+	expectStatus(t, s.serve(t, http.MethodPatch, path, patch, experimenter), http.StatusOK)
+	expectStatus(t, s.serve(t, http.MethodDelete, path, nil, experimenter), http.StatusOK)
+	expectStatus(t, s.serve(t, http.MethodDelete, path, nil, experimenter), http.StatusNotFound)
+}
+
+func TestEventPatchSkipsSchemaOfIntegrationEvents(t *testing.T) {
+	memory := newTestServer(t)
+	sqlite := newTestServer(t)
+	sqlite.store, _ = newTestSQLiteStore(t)
+
+	for name, s := range map[string]*server{"memory": memory, "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			// The deployment has no type or machines, which the DEPLOYMENT schema
+			// requires of events recorded through the API.
+			expectStatus(t, s.serveGitLab(t, gitlabDeploymentHook, "d1", testGitLabDeployment("success", "2024-03-01 12:03:00 UTC")), http.StatusOK)
+			events := storedEvents(t, s)
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want the deployment", len(events))
+			}
+
+			path := "/api/v0/events/" + strconv.FormatInt(events[0].ID, 10)
+			expectStatus(t, s.serve(t, http.MethodPatch, path, map[string]interface{}{
+				"metadata": map[string]interface{}{"rolled_back": true},
+			}, nil), http.StatusOK)
+			if metadata := metadataOf(t, storedEvents(t, s)[0]); metadata["rolled_back"] != true || metadata["environment"] != "production" {
+				t.Errorf("got metadata %v, want the patch merged", metadata)
+			}
+		})
+	}
+}
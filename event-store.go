@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+const (
+	storeMySQL  = "mysql"
+	storeSQLite = "sqlite"
+	storeMemory = "memory"
+)
+
+var (
+	errEventNotFound    = errors.New("event not found")
+	errDuplicateEventID = errors.New("duplicate event id")
+	errDuplicateKey     = errors.New("duplicate idempotency key")
+	errOrphanedKey      = errors.New("idempotency key has no event")
+)
+
+// EventStore persists events. Deleted events are kept by the store but are never
+// returned from Get or Query.
+type EventStore interface {
+	// Insert adds a new event. The event must already have been validated.
+	// Returns errDuplicateEventID if an event with the same ID exists and
+	// errDuplicateKey if the event's idempotency key has already been used.
+	Insert(ctx context.Context, event *Event) error
+	// InsertBatch inserts the events in a single transaction and returns an error
+	// for each event that could not be inserted. If atomic is set, nothing is
+	// inserted unless every event can be.
+	InsertBatch(ctx context.Context, events []*Event, atomic bool) ([]error, error)
+	// Get returns errEventNotFound if the event does not exist or was deleted.
+	Get(ctx context.Context, id int64) (*Event, error)
+	// GetByIdempotencyKey returns the event that was inserted with the key, even
+	// if it was deleted since, or errEventNotFound. It returns errOrphanedKey if
+	// the key was claimed without its event being stored.
+	GetByIdempotencyKey(ctx context.Context, key string) (*Event, error)
+	// Query expects q to have been validated.
+	Query(ctx context.Context, q *EventsQuery) (*EventsPage, error)
+	// Export calls fn with every event matching q, ignoring q.Limit, without
+	// holding them all in memory. It stops at the first error from fn.
+	Export(ctx context.Context, q *EventsQuery, fn func(*Event) error) error
+	// Update applies the patch atomically. Errors from EventPatch.Apply are
+	// returned as invalidEventError.
+	Update(ctx context.Context, id int64, patch *EventPatch) (*Event, error)
+	// Delete soft deletes the event.
+	Delete(ctx context.Context, id int64) error
+
+	ListEventTypes(ctx context.Context) ([]EventType, error)
+	// CreateEventType returns errDuplicateEventType if the name is taken.
+	CreateEventType(ctx context.Context, eventType *EventType) error
+	// UpdateEventType and DeleteEventType return errEventTypeNotFound if there is
+	// no event type with the name.
+	UpdateEventType(ctx context.Context, eventType *EventType) error
+	DeleteEventType(ctx context.Context, name string) error
+
+	// ListAPIKeys returns every API key, including revoked ones, without their
+	// hashes.
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	// GetAPIKeyByHash returns errAPIKeyNotFound unless there is an unrevoked key
+	// with the hash.
+	GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	// RevokeAPIKey returns errAPIKeyNotFound unless there is an unrevoked key with
+	// the ID.
+	RevokeAPIKey(ctx context.Context, id string) error
+
+	Close() error
+}
+
+// openDB connects to the database backing the configured SQL store.
+func (s *server) openDB() (*sql.DB, error) {
+	switch *s.Store {
+	case storeMySQL:
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true", *s.DBUser, *s.DBPassword, *s.DBHost, *s.DBPort, *s.DBName)
+		return openMySQL(dsn)
+	case storeSQLite:
+		return openSQLite(*s.SQLitePath)
+	case storeMemory:
+		return nil, fmt.Errorf("store \"%s\" is not backed by a database", *s.Store)
+	default:
+		return nil, fmt.Errorf("unknown store \"%s\"", *s.Store)
+	}
+}
+
+func (s *server) initStore() {
+	s.initEventStore()
+	s.store = instrumentedEventStore{s.store}
+
+	s.eventTypes = NewEventTypeRegistry(s.store)
+	if err := s.eventTypes.Refresh(context.Background()); err != nil {
+		fatal("failed to load event types", "error", err.Error())
+	}
+
+	if err := s.seedEventMetrics(context.Background()); err != nil {
+		slog.Warn("failed to seed event metrics", "error", err.Error())
+	}
+}
+
+func (s *server) initEventStore() {
+	if *s.Store == storeMemory {
+		s.store = NewMemoryEventStore()
+		return
+	}
+
+	db, err := s.openDB()
+	if err != nil {
+		fatal("failed to open database", "store", *s.Store, "error", err.Error())
+	}
+
+	// Refuse to serve against a schema the code does not expect.
+	mg, err := newMigrator(db, *s.Store)
+	if err != nil {
+		fatal("failed to check migrations", "error", err.Error())
+	}
+	pending, err := mg.Pending(context.Background())
+	if err != nil {
+		fatal("failed to check migrations", "error", err.Error())
+	} else if len(pending) > 0 {
+		fatal("database schema is behind; run \"migrate up\" first", "pending_migrations", len(pending))
+	}
+
+	switch *s.Store {
+	case storeMySQL:
+		s.store = NewMySQLEventStore(db)
+	case storeSQLite:
+		s.store = NewSQLiteEventStore(db)
+	}
+}
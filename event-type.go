@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Event types emitted by the built in ingest handlers. They are registered by
+// default but can be removed like any other type to stop recording them.
+const (
+	eventTypeDeployment  = "DEPLOYMENT"
+	eventTypeMerge       = "MERGE"
+	eventTypePush        = "PUSH"
+	eventTypeAppRelease  = "APP RELEASE"
+	eventTypeExperiment  = "EXPERIMENT"
+	eventTypeOpsActivity = "OPS ACTIVITY"
+	eventTypeIncident    = "INCIDENT"
+
+	maxEventTypeLength = 20
+
+	// eventTypeRefreshRate bounds how long changes made by other replicas take to
+	// be noticed, and eventTypeMissRefreshRate how often an unknown type can cause
+	// a refresh.
+	eventTypeRefreshRate     = time.Minute
+	eventTypeMissRefreshRate = 5 * time.Second
+)
+
+var (
+	errEventTypeNotFound  = errors.New("event type not found")
+	errDuplicateEventType = errors.New("event type already exists")
+
+	colorRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+	// defaultEventTypes matches the rows inserted by the event_types migration.
+	defaultEventTypes = []EventType{
+		{Name: eventTypeDeployment, DisplayName: "Deployment", Color: "#1f77b4", MetadataSchema: json.RawMessage(deploymentMetadataSchema)},
+		{Name: eventTypeMerge, DisplayName: "Merge", Color: "#2ca02c"},
+		{Name: eventTypePush, DisplayName: "Push", Color: "#98df8a"},
+		{Name: eventTypeAppRelease, DisplayName: "App Release", Color: "#9467bd"},
+		{Name: eventTypeExperiment, DisplayName: "Experiment", Color: "#ff7f0e"},
+		{Name: eventTypeOpsActivity, DisplayName: "Ops Activity", Color: "#8c564b"},
+		{Name: eventTypeIncident, DisplayName: "Incident", Color: "#d62728"},
+	}
+)
+
+// EventType is an entry in the event type registry. Only registered types can be
+// recorded.
+type EventType struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Color       string `json:"color"`
+	// SlackChannel overrides --slack-log-channel for events of this type.
+	SlackChannel string `json:"slack_channel"`
+	// MetadataSchema is an optional JSON Schema that the metadata of events of
+	// this type must match.
+	MetadataSchema json.RawMessage `json:"metadata_schema,omitempty"`
+
+	schema *jsonschema.Schema
+}
+
+// Validate enforces minimum requirements for event types and fills in defaults.
+func (t *EventType) Validate() error {
+	if len(t.Name) == 0 {
+		return fmt.Errorf("name parameter is required")
+	} else if len(t.Name) > maxEventTypeLength {
+		return fmt.Errorf("name must be at most %d characters", maxEventTypeLength)
+	} else if strings.ToUpper(t.Name) != t.Name {
+		return fmt.Errorf("name must be upper case")
+	}
+
+	if len(t.DisplayName) == 0 {
+		t.DisplayName = t.Name
+	}
+
+	if len(t.Color) == 0 {
+		t.Color = "#808080"
+	} else if !colorRegexp.MatchString(t.Color) {
+		return fmt.Errorf("color must be a hex color like \"#1f77b4\"")
+	}
+
+	return t.compile()
+}
+
+// compile compiles MetadataSchema, if there is one.
+func (t *EventType) compile() error {
+	t.schema = nil
+	if len(t.MetadataSchema) == 0 || string(t.MetadataSchema) == "null" {
+		t.MetadataSchema = nil
+		return nil
+	}
+
+	schema, err := compileMetadataSchema(t.Name, t.MetadataSchema)
+	if err != nil {
+		return err
+	}
+	t.schema = schema
+
+	return nil
+}
+
+// ValidateMetadata checks metadata against MetadataSchema, returning a
+// *MetadataError if it does not match.
+func (t *EventType) ValidateMetadata(metadata interface{}) error {
+	if t.schema == nil {
+		return nil
+	}
+	return validateMetadata(t.Name, t.schema, metadata)
+}
+
+// EventTypeRegistry caches the registered event types. Other replicas may change
+// the registry, so the cache is refreshed periodically and whenever an unknown
+// type is looked up.
+type EventTypeRegistry struct {
+	store EventStore
+
+	mu         sync.RWMutex
+	types      map[string]EventType
+	refreshed  time.Time
+	refreshing sync.Mutex
+}
+
+func NewEventTypeRegistry(store EventStore) *EventTypeRegistry {
+	return &EventTypeRegistry{store: store, types: map[string]EventType{}}
+}
+
+// Refresh reloads the registry from the store.
+func (r *EventTypeRegistry) Refresh(ctx context.Context) error {
+	r.refreshing.Lock()
+	defer r.refreshing.Unlock()
+
+	eventTypes, err := r.store.ListEventTypes(ctx)
+	if err != nil {
+		return err
+	}
+
+	types := map[string]EventType{}
+	for _, eventType := range eventTypes {
+		// A bad schema in the database should not stop events of every other type
+		// from being recorded.
+		if err := eventType.compile(); err != nil {
+			logger(ctx).Warn("ignoring metadata schema of event type", "event_type", eventType.Name, "error", err.Error())
+		}
+		types[eventType.Name] = eventType
+	}
+
+	r.mu.Lock()
+	r.types = types
+	r.refreshed = time.Now()
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Lookup returns the registered event type with the given name.
+func (r *EventTypeRegistry) Lookup(name string) (EventType, bool) {
+	r.mu.RLock()
+	eventType, ok := r.types[name]
+	age := time.Since(r.refreshed)
+	r.mu.RUnlock()
+
+	if ok && age < eventTypeRefreshRate || !ok && age < eventTypeMissRefreshRate {
+		return eventType, ok
+	}
+
+	if err := r.Refresh(context.Background()); err != nil {
+		slog.Error("failed to refresh event types", "error", err.Error())
+		return eventType, ok
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	eventType, ok = r.types[name]
+	return eventType, ok
+}
+
+// Names returns the names of every registered event type in alphabetical order.
+func (r *EventTypeRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.types))
+	for name := range r.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
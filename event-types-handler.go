@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (s *server) ListEventTypesHandler(w http.ResponseWriter, r *http.Request) {
+	eventTypes, err := s.store.ListEventTypes(r.Context())
+	if err != nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to read from database",
+			nil,
+		)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", eventTypes)
+}
+
+func (s *server) GetEventTypeHandler(w http.ResponseWriter, r *http.Request) {
+	eventType, ok := s.eventTypes.Lookup(mux.Vars(r)["name"])
+	if !ok {
+		respondWithJSON(w, http.StatusNotFound, errEventTypeNotFound, "", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", eventType)
+}
+
+func (s *server) CreateEventTypeHandler(w http.ResponseWriter, r *http.Request) {
+	eventType := EventType{}
+	if err := json.NewDecoder(r.Body).Decode(&eventType); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if err := eventType.Validate(); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if err := s.store.CreateEventType(r.Context(), &eventType); errors.Is(err, errDuplicateEventType) {
+		respondWithJSON(w, http.StatusConflict, err, "", nil)
+		return
+	} else if err != nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to write to database",
+			nil,
+		)
+		return
+	}
+
+	s.refreshEventTypes(r)
+	respondWithJSON(w, http.StatusCreated, nil, "", eventType)
+}
+
+func (s *server) UpdateEventTypeHandler(w http.ResponseWriter, r *http.Request) {
+	eventType := EventType{}
+	if err := json.NewDecoder(r.Body).Decode(&eventType); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	// The name comes from the URL; renaming would orphan existing events.
+	eventType.Name = mux.Vars(r)["name"]
+	if err := eventType.Validate(); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if err := s.store.UpdateEventType(r.Context(), &eventType); errors.Is(err, errEventTypeNotFound) {
+		respondWithJSON(w, http.StatusNotFound, err, "", nil)
+		return
+	} else if err != nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to write to database",
+			nil,
+		)
+		return
+	}
+
+	s.refreshEventTypes(r)
+	respondWithJSON(w, http.StatusOK, nil, "", eventType)
+}
+
+func (s *server) DeleteEventTypeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.DeleteEventType(r.Context(), mux.Vars(r)["name"]); errors.Is(err, errEventTypeNotFound) {
+		respondWithJSON(w, http.StatusNotFound, err, "", nil)
+		return
+	} else if err != nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to write to database",
+			nil,
+		)
+		return
+	}
+
+	s.refreshEventTypes(r)
+	respondWithJSON(w, http.StatusOK, nil, "", nil)
+}
+
+// refreshEventTypes makes changes to the registry take effect on this replica
+// immediately. Other replicas pick them up within eventTypeRefreshRate.
+func (s *server) refreshEventTypes(r *http.Request) {
+	if err := s.eventTypes.Refresh(r.Context()); err != nil {
+		logger(r.Context()).Error("failed to refresh event types", "error", err.Error())
+	}
+}
@@ -3,28 +3,66 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"math/rand"
+	"strings"
 	"time"
 )
 
+// invalidEventError marks errors caused by the request rather than the database.
+type invalidEventError struct {
+	error
+}
+
+func (e invalidEventError) Unwrap() error {
+	return e.error
+}
+
 // EventData is the Go representation of the request JSON object.
 type Event struct {
-	ID        int64       `json:"ID"`
+	ID        int64       `json:"ID,string"`
 	EventType string      `json:"event_type"`
 	Notes     string      `json:"notes"`
 	StartTime time.Time   `json:"start_time"`
 	EndTime   NullTime    `json:"end_time"`
 	Metadata  interface{} `json:"metadata"`
-	DryRun    bool        `json:"-"`
+	// APIKeyID is the API key that recorded the event, if any. It is set by the
+	// server, never by the client.
+	APIKeyID string `json:"api_key_id"`
+	DryRun   bool   `json:"-"`
+	// IdempotencyKey identifies the request that created the event, so that
+	// retries of the same request do not create duplicates.
+	IdempotencyKey string `json:"-"`
+	// slackChannel, if set, replaces the event type's Slack channel. It is set by
+	// the GitHub routing rules.
+	slackChannel string
+	// fromIntegration is set for events translated from another tool's webhooks
+	// or API, whose metadata is shaped by that tool rather than by the client.
+	// Metadata schemas only apply to events recorded through the API. It is
+	// stored with the event, so that patching the event skips them too.
+	fromIntegration bool
 }
 
-func (d *Event) ValidateAndRectify() error {
+func (d *Event) ValidateAndRectify(eventTypes *EventTypeRegistry) error {
 	if len(d.EventType) == 0 {
 		return fmt.Errorf("event_type parameter is required")
 	} else if len(d.Notes) == 0 {
 		return fmt.Errorf("notes parameter is required")
 	}
 
+	eventType, ok := eventTypes.Lookup(d.EventType)
+	if !ok {
+		return fmt.Errorf(
+			"event_type \"%s\" is not registered; must be one of \"%s\"",
+			d.EventType,
+			strings.Join(eventTypes.Names(), "\", \""),
+		)
+	}
+
+	if !d.fromIntegration {
+		if err := eventType.ValidateMetadata(d.Metadata); err != nil {
+			return err
+		}
+	}
+
 	if d.StartTime.IsZero() {
 		d.StartTime = time.Now()
 	}
@@ -33,8 +71,30 @@ func (d *Event) ValidateAndRectify() error {
 		d.EndTime.Valid = false
 	}
 
-	d.ID = rand.Int63()
+	d.ID = eventIDs.Next()
+
+	return nil
+}
 
+// UnmarshalJSON reads the ID as a string, as it is encoded, or as a number, as
+// clients that predate string IDs send it.
+func (d *Event) UnmarshalJSON(b []byte) error {
+	type event Event
+	decoded := struct {
+		*event
+		ID json.Number `json:"ID"`
+	}{event: (*event)(d)}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return err
+	} else if len(decoded.ID) == 0 {
+		return nil
+	}
+
+	id, err := decoded.ID.Int64()
+	if err != nil {
+		return fmt.Errorf("ID %q is not an integer", decoded.ID)
+	}
+	d.ID = id
 	return nil
 }
 
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/schema"
+)
+
+const (
+	defaultEventsLimit = 100
+	maxEventsLimit     = 1000
+
+	orderAscending  = "asc"
+	orderDescending = "desc"
+)
+
+// EventsQuery is the set of query parameters accepted by GET /api/v0/events.
+type EventsQuery struct {
+	EventTypes []string  `schema:"event_type"`
+	Start      time.Time `schema:"start"`
+	End        time.Time `schema:"end"`
+	Notes      string    `schema:"notes"`
+	Cursor     string    `schema:"cursor"`
+	Limit      int       `schema:"limit"`
+	Order      string    `schema:"order"`
+
+	cursor *eventsCursor
+}
+
+// Validate enforces minimum requirements for queries and fills in defaults.
+func (q *EventsQuery) Validate() error {
+	if q.Limit == 0 {
+		q.Limit = defaultEventsLimit
+	} else if q.Limit < 0 || q.Limit > maxEventsLimit {
+		return fmt.Errorf("limit must be between 1 and %d", maxEventsLimit)
+	}
+
+	q.Order = strings.ToLower(q.Order)
+	if len(q.Order) == 0 {
+		q.Order = orderDescending
+	} else if q.Order != orderAscending && q.Order != orderDescending {
+		return fmt.Errorf("order must be \"%s\" or \"%s\"", orderAscending, orderDescending)
+	}
+
+	if !q.Start.IsZero() && !q.End.IsZero() && !q.End.After(q.Start) {
+		return fmt.Errorf("end must be after start")
+	}
+
+	if len(q.Cursor) > 0 {
+		cursor, err := decodeEventsCursor(q.Cursor)
+		if err != nil {
+			return err
+		}
+		q.cursor = cursor
+	}
+
+	return nil
+}
+
+// eventsCursor marks the position of the last event on a page. Events are
+// ordered by start_time and then by id, so both are needed to resume.
+type eventsCursor struct {
+	StartTime time.Time `json:"t"`
+	ID        int64     `json:"id"`
+}
+
+func (c *eventsCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeEventsCursor(cursor string) (*eventsCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	c := &eventsCursor{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return c, nil
+}
+
+// EventsPage is a single page of results from GET /api/v0/events.
+type EventsPage struct {
+	Events     []Event `json:"events"`
+	NextCursor string  `json:"next_cursor"`
+}
+
+// newEventsPage builds a page from up to limit+1 events in order. The extra event
+// only signals that there is a next page and is dropped.
+func newEventsPage(events []Event, limit int) *EventsPage {
+	page := &EventsPage{Events: events}
+	if len(page.Events) > limit {
+		page.Events = page.Events[:limit]
+		last := page.Events[len(page.Events)-1]
+		cursor := eventsCursor{StartTime: last.StartTime, ID: last.ID}
+		page.NextCursor = cursor.Encode()
+	}
+
+	return page
+}
+
+func (s *server) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := EventsQuery{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(&query, r.URL.Query()); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if err := query.Validate(); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	page, err := s.store.Query(r.Context(), &query)
+	if err != nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to read from database",
+			nil,
+		)
+		return
+	}
+
+	if acceptsCloudEvents(r, applicationCloudEventsBatch) {
+		// A batch is a bare array, so the next page is linked from a header instead.
+		if len(page.NextCursor) > 0 {
+			next := *r.URL
+			values := next.Query()
+			values.Set("cursor", page.NextCursor)
+			next.RawQuery = values.Encode()
+			w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+		}
+		respondWithCloudEvents(w, applicationCloudEventsBatch, page.Events...)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", page)
+}
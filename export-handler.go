@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/schema"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	exportFormatCSV     = "csv"
+	exportFormatNDJSON  = "ndjson"
+	exportFormatParquet = "parquet"
+
+	// exportFlushInterval is how many rows are written between flushes, so that
+	// clients see progress on large exports.
+	exportFlushInterval = 500
+	// exportRowGroupSize bounds how much of a Parquet export is buffered before
+	// being written out.
+	exportRowGroupSize = 8 * 1024 * 1024
+)
+
+var exportContentTypes = map[string]string{
+	exportFormatCSV:     "text/csv; charset=utf-8",
+	exportFormatNDJSON:  applicationNDJSON,
+	exportFormatParquet: "application/vnd.apache.parquet",
+}
+
+// exportWriter writes events in one of the export formats.
+type exportWriter interface {
+	Write(event *Event) error
+	// Close writes anything still buffered, such as the Parquet footer.
+	Close() error
+}
+
+// metadataValue looks up a dotted path like "pull_request.number" or
+// "machines.0" in the event's metadata. Strings are returned as is and any
+// other value as JSON. Missing values are empty.
+func metadataValue(metadata interface{}, path string) (string, error) {
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal metadata to []byte")
+	}
+
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return "", err
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", nil
+			}
+			v = node[i]
+		default:
+			return "", nil
+		}
+	}
+
+	switch value := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	default:
+		b, err := json.Marshal(value)
+		return string(b), err
+	}
+}
+
+// csvExportWriter writes one row per event. Each requested metadata path gets
+// its own column; without any, the whole metadata object is one JSON column.
+type csvExportWriter struct {
+	w             *csv.Writer
+	metadataPaths []string
+}
+
+func newCSVExportWriter(w io.Writer, metadataPaths []string) (*csvExportWriter, error) {
+	cw := &csvExportWriter{w: csv.NewWriter(w), metadataPaths: metadataPaths}
+
+	header := []string{"id", "event_type", "start_time", "end_time", "notes"}
+	if len(metadataPaths) == 0 {
+		header = append(header, "metadata")
+	}
+	for _, path := range metadataPaths {
+		header = append(header, "metadata."+path)
+	}
+
+	return cw, cw.w.Write(header)
+}
+
+func (cw *csvExportWriter) Write(event *Event) error {
+	endTime := ""
+	if event.EndTime.Valid {
+		endTime = event.EndTime.Time.UTC().Format(time.RFC3339)
+	}
+
+	row := []string{
+		strconv.FormatInt(event.ID, 10),
+		event.EventType,
+		event.StartTime.UTC().Format(time.RFC3339),
+		endTime,
+		event.Notes,
+	}
+
+	if len(cw.metadataPaths) == 0 {
+		metadata, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metadata to []byte")
+		}
+		if event.Metadata == nil {
+			metadata = nil
+		}
+		row = append(row, string(metadata))
+	}
+
+	for _, path := range cw.metadataPaths {
+		value, err := metadataValue(event.Metadata, path)
+		if err != nil {
+			return err
+		}
+		row = append(row, value)
+	}
+
+	return cw.w.Write(row)
+}
+
+func (cw *csvExportWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvExportWriter) Close() error {
+	return cw.Flush()
+}
+
+// ndjsonExportWriter writes one JSON event per line, in the same shape as GET
+// /api/v0/events.
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (nw *ndjsonExportWriter) Write(event *Event) error {
+	return nw.encoder.Encode(event)
+}
+
+func (nw *ndjsonExportWriter) Close() error {
+	return nil
+}
+
+// parquetEvent is the Parquet schema of an exported event. Metadata is kept as
+// a JSON string because it differs from one event type to the next.
+type parquetEvent struct {
+	ID        int64  `parquet:"name=id, type=INT64"`
+	EventType string `parquet:"name=event_type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	StartTime int64  `parquet:"name=start_time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	EndTime   *int64 `parquet:"name=end_time, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	Notes     string `parquet:"name=notes, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Metadata  string `parquet:"name=metadata, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+type parquetExportWriter struct {
+	pw *writer.ParquetWriter
+}
+
+func newParquetExportWriter(w io.Writer) (*parquetExportWriter, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(parquetEvent), 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = exportRowGroupSize
+
+	return &parquetExportWriter{pw: pw}, nil
+}
+
+func (pw *parquetExportWriter) Write(event *Event) error {
+	row := parquetEvent{
+		ID:        event.ID,
+		EventType: event.EventType,
+		StartTime: event.StartTime.UnixNano() / int64(time.Millisecond),
+		Notes:     event.Notes,
+	}
+
+	if event.EndTime.Valid {
+		endTime := event.EndTime.Time.UnixNano() / int64(time.Millisecond)
+		row.EndTime = &endTime
+	}
+
+	if event.Metadata != nil {
+		metadata, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metadata to []byte")
+		}
+		row.Metadata = string(metadata)
+	}
+
+	return pw.pw.Write(row)
+}
+
+func (pw *parquetExportWriter) Close() error {
+	return pw.pw.WriteStop()
+}
+
+// ExportEventsHandler streams every event matching the same filters as GET
+// /api/v0/events as CSV, NDJSON or Parquet.
+func (s *server) ExportEventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := EventsQuery{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(&query, r.URL.Query()); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if err := query.Validate(); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if len(format) == 0 {
+		format = exportFormatCSV
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		respondWithJSON(
+			w,
+			http.StatusBadRequest,
+			fmt.Errorf("format must be \"%s\", \"%s\" or \"%s\"", exportFormatCSV, exportFormatNDJSON, exportFormatParquet),
+			"",
+			nil,
+		)
+		return
+	}
+
+	// Metadata paths may be repeated or comma separated.
+	metadataPaths := []string{}
+	for _, value := range r.URL.Query()["metadata"] {
+		for _, path := range strings.Split(value, ",") {
+			if path = strings.TrimSpace(path); len(path) > 0 {
+				metadataPaths = append(metadataPaths, path)
+			}
+		}
+	}
+	if len(metadataPaths) > 0 && format != exportFormatCSV {
+		respondWithJSON(w, http.StatusBadRequest, fmt.Errorf("metadata columns are only supported by the csv format"), "", nil)
+		return
+	}
+
+	// The writer is only created once the first event has been read, so that a
+	// failing query can still be reported with a proper status.
+	var ew exportWriter
+	start := func() error {
+		w.Header().Set(contentTypeHeader, contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"events.%s\"", format))
+
+		var err error
+		switch format {
+		case exportFormatCSV:
+			ew, err = newCSVExportWriter(w, metadataPaths)
+		case exportFormatNDJSON:
+			ew = &ndjsonExportWriter{encoder: json.NewEncoder(w)}
+		case exportFormatParquet:
+			ew, err = newParquetExportWriter(w)
+		}
+		return err
+	}
+
+	flusher, _ := w.(http.Flusher)
+	rows := 0
+	err := s.store.Export(r.Context(), &query, func(event *Event) error {
+		if ew == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		if err := ew.Write(event); err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushInterval == 0 && flusher != nil {
+			if cw, ok := ew.(*csvExportWriter); ok {
+				if err := cw.Flush(); err != nil {
+					return err
+				}
+			}
+			flusher.Flush()
+		}
+
+		return nil
+	})
+	if err != nil && ew == nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to read from database",
+			nil,
+		)
+		return
+	}
+
+	// An empty export is still a valid file with a header.
+	if err == nil && ew == nil {
+		err = start()
+	}
+	if err == nil {
+		err = ew.Close()
+	}
+
+	// The status has been sent by now, so all that can be done is to cut the
+	// export short.
+	if err != nil {
+		logger(r.Context()).Error("failed to export events", "rows", rows, "error", err.Error())
+	}
+}
@@ -6,10 +6,10 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
 )
@@ -20,24 +20,98 @@ const (
 	githubEventHeader     = "X-GitHub-Event"
 	githubDeliverHeader   = "X-GitHub-Delivery"
 
-	pullRequestEvent = "pull_request"
-	pushEvent        = "push"
-	pingEvent        = "ping"
+	pullRequestEvent      = "pull_request"
+	pushEvent             = "push"
+	pingEvent             = "ping"
+	releaseEvent          = "release"
+	createEvent           = "create"
+	deleteEvent           = "delete"
+	registryPackageEvent  = "registry_package"
+	deploymentEvent       = "deployment"
+	deploymentStatusEvent = "deployment_status"
+	workflowRunEvent      = "workflow_run"
 )
 
 var (
 	validEvents = map[string]bool{
-		pullRequestEvent: true,
-		pingEvent:        true,
-		pushEvent:        true,
+		pullRequestEvent:      true,
+		pingEvent:             true,
+		pushEvent:             true,
+		releaseEvent:          true,
+		createEvent:           true,
+		deleteEvent:           true,
+		registryPackageEvent:  true,
+		deploymentEvent:       true,
+		deploymentStatusEvent: true,
+		workflowRunEvent:      true,
 	}
 )
 
+// GitHubWebHookValidator checks the signatures of GitHub webhooks. A webhook
+// about a repository listed in RepositorySecrets, or about a repository of an
+// organization listed there, must be signed with one of its secrets. Any other
+// webhook must be signed with one of Secrets. Several secrets are accepted so
+// that they can be rotated without downtime.
 type GitHubWebHookValidator struct {
-	Secret []byte
+	Secrets [][]byte
+	// RepositorySecrets is keyed by lower case "owner/repository" or "owner".
+	RepositorySecrets map[string][][]byte
+	// AllowSHA1 accepts webhooks that only have the legacy SHA-1 signature.
+	AllowSHA1 bool
 }
 
-func (v *GitHubWebHookValidator) verifySignatureSHA1(signature string, body []byte) bool {
+func NewGitHubWebHookValidator(secrets []string, repositorySecrets map[string][]string, allowSHA1 bool) *GitHubWebHookValidator {
+	v := &GitHubWebHookValidator{
+		Secrets:           toBytes(secrets),
+		RepositorySecrets: map[string][][]byte{},
+		AllowSHA1:         allowSHA1,
+	}
+	for name, secrets := range repositorySecrets {
+		v.RepositorySecrets[strings.ToLower(name)] = toBytes(secrets)
+	}
+	return v
+}
+
+func toBytes(secrets []string) [][]byte {
+	b := make([][]byte, 0, len(secrets))
+	for _, secret := range secrets {
+		b = append(b, []byte(secret))
+	}
+	return b
+}
+
+// secretsFor returns the secrets that the webhook with the payload may be signed
+// with.
+func (v *GitHubWebHookValidator) secretsFor(payload []byte) [][]byte {
+	if len(v.RepositorySecrets) == 0 {
+		return v.Secrets
+	}
+
+	hook := struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+		Organization struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+	}{}
+	json.Unmarshal(payload, &hook)
+
+	repository := strings.ToLower(hook.Repository.FullName)
+	owner := strings.ToLower(hook.Organization.Login)
+	if i := strings.Index(repository, "/"); i > 0 {
+		owner = repository[:i]
+	}
+
+	if secrets, ok := v.RepositorySecrets[repository]; ok && len(repository) > 0 {
+		return secrets
+	} else if secrets, ok := v.RepositorySecrets[owner]; ok && len(owner) > 0 {
+		return secrets
+	}
+	return v.Secrets
+}
+
+func (v *GitHubWebHookValidator) verifySignatureSHA1(secrets [][]byte, signature string, body []byte) bool {
 	const signaturePrefix = "sha1="
 	const signatureLength = 45 // len(SignaturePrefix) + len(hex(sha1))
 
@@ -48,14 +122,17 @@ func (v *GitHubWebHookValidator) verifySignatureSHA1(signature string, body []by
 	actual := make([]byte, 20)
 	hex.Decode(actual, []byte(signature[5:]))
 
-	computed := hmac.New(sha1.New, v.Secret)
-	computed.Write(body)
-	signed := []byte(computed.Sum(nil))
-
-	return hmac.Equal(signed, actual)
+	for _, secret := range secrets {
+		computed := hmac.New(sha1.New, secret)
+		computed.Write(body)
+		if hmac.Equal(computed.Sum(nil), actual) {
+			return true
+		}
+	}
+	return false
 }
 
-func (v *GitHubWebHookValidator) verifySignatureSHA256(signature string, body []byte) bool {
+func (v *GitHubWebHookValidator) verifySignatureSHA256(secrets [][]byte, signature string, body []byte) bool {
 	const signaturePrefix = "sha256="
 	const signatureLength = 71 // len(SignaturePrefix) + len(hex(sha256))
 
@@ -66,22 +143,23 @@ func (v *GitHubWebHookValidator) verifySignatureSHA256(signature string, body []
 	actual := make([]byte, 32)
 	hex.Decode(actual, []byte(signature[7:]))
 
-	computed := hmac.New(sha256.New, v.Secret)
-	computed.Write(body)
-	signed := []byte(computed.Sum(nil))
-
-	return hmac.Equal(signed, actual)
+	for _, secret := range secrets {
+		computed := hmac.New(sha256.New, secret)
+		computed.Write(body)
+		if hmac.Equal(computed.Sum(nil), actual) {
+			return true
+		}
+	}
+	return false
 }
 
+// parseHook checks the SHA-256 signature, or the SHA-1 signature if that is the
+// only one and it is allowed.
 func (v *GitHubWebHookValidator) parseHook(req *http.Request) error {
 	signatureSHA1 := req.Header.Get(signatureSHA1Header)
 	signatureSHA256 := req.Header.Get(signatureSHA256Header)
 
-	if len(signatureSHA1) == 0 {
-		return fmt.Errorf("Missing \"%s\" header", signatureSHA1Header)
-	}
-
-	if len(signatureSHA256) == 0 {
+	if len(signatureSHA256) == 0 && (len(signatureSHA1) == 0 || !v.AllowSHA1) {
 		return fmt.Errorf("Missing \"%s\" header", signatureSHA256Header)
 	}
 
@@ -92,17 +170,18 @@ func (v *GitHubWebHookValidator) parseHook(req *http.Request) error {
 	req.Body.Close()
 	req.Body = ioutil.NopCloser(bytes.NewBuffer(payload))
 
-	if !v.verifySignatureSHA1(signatureSHA1, payload) {
+	secrets := v.secretsFor(payload)
+	if len(signatureSHA256) > 0 {
+		if !v.verifySignatureSHA256(secrets, signatureSHA256, payload) {
+			return errors.New("Invalid SHA256 signature")
+		}
+	} else if !v.verifySignatureSHA1(secrets, signatureSHA1, payload) {
 		return errors.New("Invalid SHA1 signature")
 	}
 
-	if !v.verifySignatureSHA256(signatureSHA256, payload) {
-		return errors.New("Invalid SHA256 signature")
-	}
-
 	githubEvent := req.Header.Get(githubEventHeader)
 	if !validEvents[githubEvent] {
-		log.Printf("GitHub event type \"%s\" not handled", githubEvent)
+		logger(req.Context()).Info("GitHub event type not handled", "github_event", githubEvent)
 	}
 
 	return nil
@@ -110,7 +189,10 @@ func (v *GitHubWebHookValidator) parseHook(req *http.Request) error {
 
 func (v *GitHubWebHookValidator) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if err := v.parseHook(r); err != nil {
+		_, span := tracer.Start(r.Context(), "GitHubWebHookValidator")
+		err := v.parseHook(r)
+		endSpan(span, err)
+		if err != nil {
 			respondWithJSON(w, http.StatusBadRequest, err, "", nil)
 			return
 		}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func githubSignature(h func() hash.Hash, prefix, secret string, body []byte) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return prefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateGitHubHook runs the webhook through the validator's middleware and
+// returns the response status.
+func validateGitHubHook(v *GitHubWebHookValidator, body []byte, headers map[string]string) int {
+	handler := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v0/github", bytes.NewReader(body))
+	r.Header.Set(githubEventHeader, pushEvent)
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestGitHubWebHookValidatorSecretRotation(t *testing.T) {
+	v := NewGitHubWebHookValidator([]string{"new-secret", "old-secret"}, nil, false)
+	body := []byte(`{"repository": {"full_name": "octo/app"}}`)
+
+	for secret, want := range map[string]int{
+		"new-secret":     http.StatusNoContent,
+		"old-secret":     http.StatusNoContent,
+		"revoked-secret": http.StatusBadRequest,
+	} {
+		signature := githubSignature(sha256.New, "sha256=", secret, body)
+		if got := validateGitHubHook(v, body, map[string]string{signatureSHA256Header: signature}); got != want {
+			t.Errorf("signed with %s: got status %d, want %d", secret, got, want)
+		}
+	}
+
+	if got := validateGitHubHook(v, body, nil); got != http.StatusBadRequest {
+		t.Errorf("unsigned: got status %d, want %d", got, http.StatusBadRequest)
+	}
+	tampered := githubSignature(sha256.New, "sha256=", "new-secret", body)
+	if got := validateGitHubHook(v, []byte(`{}`), map[string]string{signatureSHA256Header: tampered}); got != http.StatusBadRequest {
+		t.Errorf("tampered body: got status %d, want %d", got, http.StatusBadRequest)
+	}
+}
+
+func TestGitHubWebHookValidatorSHA1(t *testing.T) {
+	body := []byte(`{}`)
+	headers := map[string]string{signatureSHA1Header: githubSignature(sha1.New, "sha1=", "secret", body)}
+
+	if defaultConfig().GitHubAllowSHA1 {
+		t.Error("SHA-1 signatures are allowed by default")
+	}
+	if got := validateGitHubHook(NewGitHubWebHookValidator([]string{"secret"}, nil, false), body, headers); got != http.StatusBadRequest {
+		t.Errorf("SHA-1 only, not allowed: got status %d, want %d", got, http.StatusBadRequest)
+	}
+	if got := validateGitHubHook(NewGitHubWebHookValidator([]string{"secret"}, nil, true), body, headers); got != http.StatusNoContent {
+		t.Errorf("SHA-1 only, allowed: got status %d, want %d", got, http.StatusNoContent)
+	}
+
+	// A SHA-256 signature, when there is one, is the one that counts.
+	headers[signatureSHA256Header] = githubSignature(sha256.New, "sha256=", "other", body)
+	if got := validateGitHubHook(NewGitHubWebHookValidator([]string{"secret"}, nil, true), body, headers); got != http.StatusBadRequest {
+		t.Errorf("bad SHA-256 with good SHA-1: got status %d, want %d", got, http.StatusBadRequest)
+	}
+}
+
+func TestGitHubWebHookValidatorRepositorySecrets(t *testing.T) {
+	v := NewGitHubWebHookValidator([]string{"global"}, map[string][]string{
+		"Octo/App": {"app-new", "app-old"},
+		"octo-org": {"org"},
+	}, false)
+
+	for _, test := range []struct {
+		body, secret string
+		want         int
+	}{
+		{`{"repository": {"full_name": "octo/app"}}`, "app-new", http.StatusNoContent},
+		{`{"repository": {"full_name": "octo/app"}}`, "app-old", http.StatusNoContent},
+		{`{"repository": {"full_name": "octo/app"}}`, "global", http.StatusBadRequest},
+		{`{"repository": {"full_name": "octo-org/api"}}`, "org", http.StatusNoContent},
+		{`{"repository": {"full_name": "octo-org/api"}}`, "global", http.StatusBadRequest},
+		{`{"organization": {"login": "octo-org"}}`, "org", http.StatusNoContent},
+		{`{"repository": {"full_name": "someone/else"}}`, "global", http.StatusNoContent},
+		{`{"repository": {"full_name": "someone/else"}}`, "app-new", http.StatusBadRequest},
+	} {
+		body := []byte(test.body)
+		headers := map[string]string{signatureSHA256Header: githubSignature(sha256.New, "sha256=", test.secret, body)}
+		if got := validateGitHubHook(v, body, headers); got != test.want {
+			t.Errorf("%s signed with %s: got status %d, want %d", test.body, test.secret, got, test.want)
+		}
+	}
+}
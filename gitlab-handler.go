@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	vcsGitLab = "gitlab"
+
+	// zeroSHA is the before or after SHA of a push that creates or deletes a ref.
+	zeroSHA = "0000000000000000000000000000000000000000"
+)
+
+// terminalGitLabStates are the states that GitLab pipelines and deployments end
+// in.
+var terminalGitLabStates = map[string]bool{
+	"success":  true,
+	"failed":   true,
+	"canceled": true,
+}
+
+// gitlabTime reads the timestamps of GitLab webhooks, which come in several
+// formats, e.g. "2021-04-28T21:50:00Z", "2021-04-28 21:50:00 UTC" and
+// "2021-04-28 21:50:00 +0200".
+type gitlabTime struct {
+	time.Time
+}
+
+func (t *gitlabTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	} else if len(s) == 0 {
+		return nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05 MST", "2006-01-02 15:04:05 -0700"} {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid GitLab time %q", s)
+}
+
+// GitLabProjectData is the part of every webhook payload that identifies the
+// project.
+type GitLabProjectData struct {
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+		DefaultBranch     string `json:"default_branch"`
+	} `json:"project"`
+}
+
+// GitLabPushData is the payload of both push and tag push webhooks.
+type GitLabPushData struct {
+	GitLabProjectData
+	Before       string `json:"before"`
+	After        string `json:"after"`
+	Ref          string `json:"ref"`
+	UserUsername string `json:"user_username"`
+	Commits      []struct {
+		ID        string     `json:"id"`
+		Message   string     `json:"message"`
+		Timestamp gitlabTime `json:"timestamp"`
+		URL       string     `json:"url"`
+		Author    struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+}
+
+type GitLabMergeRequestData struct {
+	GitLabProjectData
+	User struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	ObjectAttributes struct {
+		IID            int64      `json:"iid"`
+		Title          string     `json:"title"`
+		Description    string     `json:"description"`
+		URL            string     `json:"url"`
+		Action         string     `json:"action"`
+		SourceBranch   string     `json:"source_branch"`
+		TargetBranch   string     `json:"target_branch"`
+		MergeCommitSHA string     `json:"merge_commit_sha"`
+		UpdatedAt      gitlabTime `json:"updated_at"`
+	} `json:"object_attributes"`
+}
+
+type GitLabPipelineData struct {
+	GitLabProjectData
+	User struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	ObjectAttributes struct {
+		ID         int64      `json:"id"`
+		Name       string     `json:"name"`
+		Ref        string     `json:"ref"`
+		Tag        bool       `json:"tag"`
+		SHA        string     `json:"sha"`
+		Source     string     `json:"source"`
+		Status     string     `json:"status"`
+		URL        string     `json:"url"`
+		CreatedAt  gitlabTime `json:"created_at"`
+		FinishedAt gitlabTime `json:"finished_at"`
+	} `json:"object_attributes"`
+}
+
+// allowlistNames are the names that the pipeline is allowlisted by, most
+// specific first: its name, which is only set by workflow:name, then its
+// project and ref as "<project>@<ref>", then its source, e.g. "schedule".
+func (d *GitLabPipelineData) allowlistNames() []string {
+	names := []string{}
+	if len(d.ObjectAttributes.Name) > 0 {
+		names = append(names, d.ObjectAttributes.Name)
+	}
+	names = append(names, d.Project.PathWithNamespace+"@"+d.ObjectAttributes.Ref)
+	if len(d.ObjectAttributes.Source) > 0 {
+		names = append(names, d.ObjectAttributes.Source)
+	}
+	return names
+}
+
+type GitLabDeploymentData struct {
+	GitLabProjectData
+	Status          string     `json:"status"`
+	StatusChangedAt gitlabTime `json:"status_changed_at"`
+	DeploymentID    int64      `json:"deployment_id"`
+	DeployableURL   string     `json:"deployable_url"`
+	Environment     string     `json:"environment"`
+	ShortSHA        string     `json:"short_sha"`
+	Ref             string     `json:"ref"`
+	CommitTitle     string     `json:"commit_title"`
+	User            struct {
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+// GitLabPushHandler records pushes to the default branch as PUSH events.
+func (s *server) GitLabPushHandler(w http.ResponseWriter, r *http.Request) {
+	request := GitLabPushData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if request.After == zeroSHA || request.Ref != "refs/heads/"+request.Project.DefaultBranch {
+		respondWithJSON(w, http.StatusOK, nil, "", nil)
+		return
+	}
+
+	metadata := &VCSMetadata{
+		VCS:           vcsGitLab,
+		VCSEvent:      "push",
+		Repository:    request.Project.PathWithNamespace,
+		RepositoryURL: request.Project.WebURL,
+		Ref:           request.Ref,
+		SHA:           request.After,
+		Author:        request.UserUsername,
+		Commits:       []VCSCommit{},
+	}
+
+	event := &Event{EventType: eventTypePush, Metadata: metadata}
+	for _, commit := range request.Commits {
+		metadata.Commits = append(metadata.Commits, VCSCommit{SHA: commit.ID, Message: commit.Message, Author: commit.Author.Name, URL: commit.URL})
+		if commit.ID == request.After {
+			event.StartTime = commit.Timestamp.Time
+			event.Notes = strings.TrimSpace(commit.Message)
+			metadata.Title = strings.SplitN(event.Notes, "\n", 2)[0]
+			metadata.URL = commit.URL
+		}
+	}
+	if len(event.Notes) == 0 {
+		event.Notes = fmt.Sprintf("Push to %s", request.Project.PathWithNamespace)
+	}
+
+	s.recordGitLabEvent(w, r, event)
+}
+
+// GitLabTagPushHandler records tags being created or deleted as APP RELEASE
+// events, as the GitHub TagHandler does.
+func (s *server) GitLabTagPushHandler(w http.ResponseWriter, r *http.Request) {
+	request := GitLabPushData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	tag := strings.TrimPrefix(request.Ref, "refs/tags/")
+	action, preposition, sha := "created", "in", request.After
+	if request.After == zeroSHA {
+		action, preposition, sha = "deleted", "from", request.Before
+	}
+
+	s.recordGitLabEvent(w, r, &Event{
+		EventType: eventTypeAppRelease,
+		Notes:     fmt.Sprintf("Tag %s %s %s %s", tag, action, preposition, request.Project.PathWithNamespace),
+		Metadata: &VCSMetadata{
+			VCS:           vcsGitLab,
+			VCSEvent:      "tag_push",
+			Repository:    request.Project.PathWithNamespace,
+			RepositoryURL: request.Project.WebURL,
+			Ref:           request.Ref,
+			Tag:           tag,
+			SHA:           sha,
+			Author:        request.UserUsername,
+			Action:        action,
+		},
+	})
+}
+
+// GitLabMergeRequestHandler records merged merge requests as MERGE events.
+func (s *server) GitLabMergeRequestHandler(w http.ResponseWriter, r *http.Request) {
+	request := GitLabMergeRequestData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	mr := request.ObjectAttributes
+	if mr.Action != "merge" {
+		respondWithJSON(w, http.StatusOK, nil, "", nil)
+		return
+	}
+
+	s.recordGitLabEvent(w, r, &Event{
+		EventType: eventTypeMerge,
+		StartTime: mr.UpdatedAt.Time,
+		Notes:     mr.Title,
+		Metadata: &VCSMetadata{
+			VCS:           vcsGitLab,
+			VCSEvent:      "merge_request",
+			Repository:    request.Project.PathWithNamespace,
+			RepositoryURL: request.Project.WebURL,
+			Ref:           "refs/heads/" + mr.TargetBranch,
+			SHA:           mr.MergeCommitSHA,
+			Author:        request.User.Username,
+			Action:        "merged",
+			ID:            mr.IID,
+			Title:         mr.Title,
+			Description:   mr.Description,
+			URL:           mr.URL,
+			SourceBranch:  mr.SourceBranch,
+			TargetBranch:  mr.TargetBranch,
+		},
+	})
+}
+
+// GitLabPipelineHandler records finished pipelines named by
+// --gitlab-deploy-pipelines and --gitlab-ops-pipelines, as WorkflowRunHandler
+// does for GitHub Actions.
+func (s *server) GitLabPipelineHandler(w http.ResponseWriter, r *http.Request) {
+	request := GitLabPipelineData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	pipeline := request.ObjectAttributes
+	eventType, ok := "", false
+	for _, name := range request.allowlistNames() {
+		if eventType, ok = s.GitLabPipelines[name]; ok {
+			break
+		}
+	}
+	if !terminalGitLabStates[pipeline.Status] || !ok {
+		respondWithJSON(w, http.StatusOK, nil, "", nil)
+		return
+	}
+
+	name := pipeline.Name
+	if len(name) == 0 {
+		name = "Pipeline"
+	}
+
+	ref := "refs/heads/" + pipeline.Ref
+	if pipeline.Tag {
+		ref = "refs/tags/" + pipeline.Ref
+	}
+
+	event := &Event{
+		EventType: eventType,
+		StartTime: pipeline.CreatedAt.Time,
+		Notes:     fmt.Sprintf("%s #%d %s in %s", name, pipeline.ID, pipeline.Status, request.Project.PathWithNamespace),
+		Metadata: &VCSMetadata{
+			VCS:           vcsGitLab,
+			VCSEvent:      "pipeline",
+			Repository:    request.Project.PathWithNamespace,
+			RepositoryURL: request.Project.WebURL,
+			Ref:           ref,
+			SHA:           pipeline.SHA,
+			Author:        request.User.Username,
+			ID:            pipeline.ID,
+			Name:          pipeline.Name,
+			URL:           pipeline.URL,
+			State:         pipeline.Status,
+		},
+		// Retrying a pipeline's jobs finishes it again, but it is recorded once.
+		IdempotencyKey: "gitlab-pipeline:" + request.Project.PathWithNamespace + ":" + strconv.FormatInt(pipeline.ID, 10),
+	}
+	event.EndTime.Time = pipeline.FinishedAt.Time
+	event.EndTime.Valid = !pipeline.FinishedAt.IsZero()
+
+	s.recordGitLabEvent(w, r, event)
+}
+
+// GitLabDeploymentHandler opens a DEPLOYMENT event when a GitLab deployment
+// starts, and ends it when the deployment finishes, as the GitHub deployment
+// handlers do.
+func (s *server) GitLabDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	request := GitLabDeploymentData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if _, ok := s.eventTypes.Lookup(eventTypeDeployment); !ok {
+		respondWithJSON(w, http.StatusOK, nil, fmt.Sprintf("event type \"%s\" is not registered", eventTypeDeployment), nil)
+		return
+	}
+
+	repository := request.Project.PathWithNamespace
+	event := &Event{
+		EventType: eventTypeDeployment,
+		StartTime: request.StatusChangedAt.Time,
+		Notes:     fmt.Sprintf("Deploy %s@%s to %s", repository, request.Ref, request.Environment),
+		Metadata: &VCSMetadata{
+			VCS:           vcsGitLab,
+			VCSEvent:      "deployment",
+			Repository:    repository,
+			RepositoryURL: request.Project.WebURL,
+			Ref:           request.Ref,
+			SHA:           request.ShortSHA,
+			Author:        request.User.Username,
+			ID:            request.DeploymentID,
+			Title:         request.CommitTitle,
+			URL:           request.DeployableURL,
+			Environment:   request.Environment,
+			State:         request.Status,
+		},
+		IdempotencyKey:  "gitlab-deployment:" + repository + ":" + strconv.FormatInt(request.DeploymentID, 10),
+		fromIntegration: true,
+	}
+
+	s.updateDeployment(
+		w,
+		r,
+		event,
+		map[string]interface{}{"state": request.Status},
+		request.StatusChangedAt.Time,
+		terminalGitLabStates,
+	)
+}
+
+// recordGitLabEvent records an event from a GitLab webhook, unless its event type
+// has been unregistered.
+func (s *server) recordGitLabEvent(w http.ResponseWriter, r *http.Request, event *Event) {
+	if _, ok := s.eventTypes.Lookup(event.EventType); !ok {
+		respondWithJSON(w, http.StatusOK, nil, fmt.Sprintf("event type \"%s\" is not registered", event.EventType), event.Metadata)
+		return
+	}
+
+	if uuid := r.Header.Get(gitlabEventUUIDHeader); len(uuid) > 0 && len(event.IdempotencyKey) == 0 {
+		event.IdempotencyKey = "gitlab:" + uuid
+	}
+	event.fromIntegration = true
+
+	if err := s.writeToDBAndLog(r.Context(), event); err != nil {
+		respondWithWriteError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", event)
+}
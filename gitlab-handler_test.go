@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// serveGitLab sends a GitLab webhook with testGitLabToken.
+func (s *server) serveGitLab(t *testing.T, hook, uuid string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	return s.serve(t, http.MethodPost, "/api/v0/gitlab", body, map[string]string{
+		gitlabTokenHeader:     testGitLabToken,
+		gitlabEventHeader:     hook,
+		gitlabEventUUIDHeader: uuid,
+	})
+}
+
+func testGitLabProject() map[string]interface{} {
+	return map[string]interface{}{
+		"path_with_namespace": "octo/app",
+		"web_url":             "https://gitlab.com/octo/app",
+		"default_branch":      "main",
+	}
+}
+
+func testGitLabDeployment(status, changedAt string) map[string]interface{} {
+	return map[string]interface{}{
+		"object_kind":       "deployment",
+		"project":           testGitLabProject(),
+		"status":            status,
+		"status_changed_at": changedAt,
+		"deployment_id":     15,
+		"deployable_url":    "https://gitlab.com/octo/app/-/jobs/1",
+		"environment":       "production",
+		"short_sha":         "a10867b1",
+		"ref":               "main",
+		"commit_title":      "Fix typo",
+		"user":              map[string]interface{}{"username": "octocat"},
+	}
+}
+
+func testGitLabPipeline(name, status string) map[string]interface{} {
+	return map[string]interface{}{
+		"object_kind": "pipeline",
+		"project":     testGitLabProject(),
+		"user":        map[string]interface{}{"username": "octocat"},
+		"object_attributes": map[string]interface{}{
+			"id":          31,
+			"name":        name,
+			"ref":         "main",
+			"sha":         "a10867b14bb761a232cd80139fbd4c0d33264240",
+			"status":      status,
+			"url":         "https://gitlab.com/octo/app/-/pipelines/31",
+			"created_at":  "2024-03-01 12:00:00 UTC",
+			"finished_at": "2024-03-01 12:04:00 UTC",
+		},
+	}
+}
+
+func TestGitLabDeploymentHandler(t *testing.T) {
+	s := newTestServer(t)
+	slack := useFakeSlack(t, s, "#deploys")
+
+	// The deployment has no type or machines, which the DEPLOYMENT schema
+	// requires of events recorded through the API.
+	for i, status := range []struct {
+		state, changedAt string
+	}{
+		{"running", "2024-03-01 12:00:00 UTC"},
+		{"success", "2024-03-01 12:03:00 UTC"},
+		// Redeliveries and states after the deployment finished are ignored.
+		{"success", "2024-03-01 12:03:00 UTC"},
+		{"canceled", "2024-03-01 12:05:00 UTC"},
+	} {
+		expectStatus(t, s.serveGitLab(t, gitlabDeploymentHook, "d"+strconv.Itoa(i), testGitLabDeployment(status.state, status.changedAt)), http.StatusOK)
+	}
+
+	events := storedEvents(t, s)
+	if len(events) != 1 || events[0].EventType != eventTypeDeployment {
+		t.Fatalf("got %+v, want one DEPLOYMENT", events)
+	}
+	if metadata := metadataOf(t, events[0]); metadata["state"] != "success" || metadata["environment"] != "production" {
+		t.Errorf("got metadata %v, want the successful deployment", metadata)
+	}
+	if !events[0].EndTime.Valid || events[0].EndTime.Time.Format("15:04") != "12:03" {
+		t.Errorf("got end time %v, want the success's", events[0].EndTime)
+	}
+	if posted := slack.posted(); len(posted) != 2 {
+		t.Errorf("posted %q, want the deployment and its success", posted)
+	}
+}
+
+func TestGitLabPipelineHandler(t *testing.T) {
+	s := newTestServer(t)
+	s.GitLabPipelines = workflowEventTypes("Deploy", "Rotate keys")
+
+	expectStatus(t, s.serveGitLab(t, gitlabPipelineHook, "p1", testGitLabPipeline("Deploy", "running")), http.StatusOK)
+	expectStatus(t, s.serveGitLab(t, gitlabPipelineHook, "p2", testGitLabPipeline("Deploy", "failed")), http.StatusOK)
+	// Retrying the pipeline's jobs finishes it again.
+	expectStatus(t, s.serveGitLab(t, gitlabPipelineHook, "p3", testGitLabPipeline("Deploy", "failed")), http.StatusOK)
+	expectStatus(t, s.serveGitLab(t, gitlabPipelineHook, "p4", testGitLabPipeline("Test", "success")), http.StatusOK)
+
+	events := storedEvents(t, s)
+	if len(events) != 1 || events[0].EventType != eventTypeDeployment {
+		t.Fatalf("got %+v, want one DEPLOYMENT for the deploy pipeline", events)
+	} else if !strings.Contains(events[0].Notes, "Deploy #31 failed in octo/app") {
+		t.Errorf("got notes %q", events[0].Notes)
+	} else if metadata := metadataOf(t, events[0]); metadata["state"] != "failed" || metadata["vcs_event"] != "pipeline" {
+		t.Errorf("got metadata %v, want the failed pipeline", metadata)
+	} else if !events[0].EndTime.Valid {
+		t.Error("the pipeline has no end time")
+	}
+}
+
+func TestGitLabPipelineHandlerWithoutName(t *testing.T) {
+	s := newTestServer(t)
+	slack := useFakeSlack(t, s, "#events")
+	s.GitLabPipelines = workflowEventTypes("octo/app@main", "schedule")
+
+	// Pipelines only have a name if they set workflow:name.
+	expectStatus(t, s.serveGitLab(t, gitlabPipelineHook, "p1", testGitLabPipeline("", "success")), http.StatusOK)
+	scheduled := testGitLabPipeline("", "success")
+	attributes := scheduled["object_attributes"].(map[string]interface{})
+	attributes["id"], attributes["ref"], attributes["source"] = 32, "nightly", "schedule"
+	expectStatus(t, s.serveGitLab(t, gitlabPipelineHook, "p2", scheduled), http.StatusOK)
+	other := testGitLabPipeline("", "success")
+	other["object_attributes"].(map[string]interface{})["ref"] = "feature"
+	expectStatus(t, s.serveGitLab(t, gitlabPipelineHook, "p3", other), http.StatusOK)
+
+	events := storedEvents(t, s)
+	if len(events) != 2 || events[0].EventType != eventTypeDeployment || events[1].EventType != eventTypeOpsActivity {
+		t.Fatalf("got %+v, want the main pipeline as a DEPLOYMENT and the scheduled one as OPS ACTIVITY", events)
+	} else if !strings.Contains(events[0].Notes, "Pipeline #31 success in octo/app") {
+		t.Errorf("got notes %q", events[0].Notes)
+	}
+	if posted := slack.posted(); len(posted) != 2 || !strings.Contains(posted[0], "*Pipeline #31 in octo/app: success*") {
+		t.Errorf("posted %q, want both pipelines", posted)
+	}
+}
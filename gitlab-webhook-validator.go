@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	gitlabTokenHeader     = "X-Gitlab-Token"
+	gitlabEventHeader     = "X-Gitlab-Event"
+	gitlabEventUUIDHeader = "X-Gitlab-Event-UUID"
+
+	gitlabPushHook         = "Push Hook"
+	gitlabTagPushHook      = "Tag Push Hook"
+	gitlabMergeRequestHook = "Merge Request Hook"
+	gitlabPipelineHook     = "Pipeline Hook"
+	gitlabDeploymentHook   = "Deployment Hook"
+)
+
+var (
+	validGitLabEvents = map[string]bool{
+		gitlabPushHook:         true,
+		gitlabTagPushHook:      true,
+		gitlabMergeRequestHook: true,
+		gitlabPipelineHook:     true,
+		gitlabDeploymentHook:   true,
+	}
+)
+
+// GitLabWebHookValidator checks the secret token of GitLab webhooks. Any of
+// Tokens is accepted, so that the token can be rotated without downtime.
+type GitLabWebHookValidator struct {
+	Tokens [][]byte
+}
+
+func (v *GitLabWebHookValidator) parseHook(req *http.Request) error {
+	token := req.Header.Get(gitlabTokenHeader)
+	if len(token) == 0 {
+		return fmt.Errorf("Missing \"%s\" header", gitlabTokenHeader)
+	}
+
+	valid := false
+	for _, t := range v.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), t) == 1 {
+			valid = true
+		}
+	}
+	if !valid {
+		return errors.New("Invalid token")
+	}
+
+	gitlabEvent := req.Header.Get(gitlabEventHeader)
+	if !validGitLabEvents[gitlabEvent] {
+		logger(req.Context()).Info("GitLab event type not handled", "gitlab_event", gitlabEvent)
+	}
+
+	return nil
+}
+
+func (v *GitLabWebHookValidator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracer.Start(r.Context(), "GitLabWebHookValidator")
+		err := v.parseHook(r)
+		endSpan(span, err)
+		if err != nil {
+			respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
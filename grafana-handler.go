@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The Grafana JSON data source expects these column types in table responses.
+const (
+	grafanaColumnTime   = "time"
+	grafanaColumnString = "string"
+
+	grafanaTargetTable = "table"
+)
+
+// errGrafanaLimit stops reading events once a response is full.
+var errGrafanaLimit = errors.New("grafana response limit reached")
+
+// GrafanaRange is the time range of the dashboard a request comes from.
+type GrafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// GrafanaAnnotationRequest is the body of POST /grafana/annotations.
+type GrafanaAnnotationRequest struct {
+	Range      GrafanaRange `json:"range"`
+	Annotation struct {
+		Name       string      `json:"name"`
+		Datasource interface{} `json:"datasource"`
+		Enable     bool        `json:"enable"`
+		IconColor  string      `json:"iconColor"`
+		Query      string      `json:"query"`
+	} `json:"annotation"`
+}
+
+// GrafanaAnnotation is a single annotation in the response to POST
+// /grafana/annotations. Events with an end time become region annotations.
+type GrafanaAnnotation struct {
+	Annotation interface{} `json:"annotation"`
+	Time       int64       `json:"time"`
+	TimeEnd    int64       `json:"timeEnd,omitempty"`
+	IsRegion   bool        `json:"isRegion"`
+	Title      string      `json:"title"`
+	Text       string      `json:"text"`
+	Tags       []string    `json:"tags"`
+}
+
+// GrafanaSearchRequest is the body of POST /grafana/search.
+type GrafanaSearchRequest struct {
+	Target string `json:"target"`
+}
+
+// GrafanaQueryRequest is the body of POST /grafana/query. Every target is
+// either an event type name or a filter, as in annotation queries.
+type GrafanaQueryRequest struct {
+	Range   GrafanaRange `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+		Type   string `json:"type"`
+	} `json:"targets"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+type grafanaTimeSeries struct {
+	Target     string           `json:"target"`
+	Datapoints [][2]interface{} `json:"datapoints"`
+}
+
+// grafanaFilter selects events for Grafana. It is written like a URL query,
+// e.g. "event_type=DEPLOYMENT,INCIDENT&service=api", where every key other than
+// event_type is a dotted path into the metadata that must have the given value.
+type grafanaFilter struct {
+	EventTypes []string
+	Metadata   url.Values
+}
+
+func parseGrafanaFilter(query string) (*grafanaFilter, error) {
+	values, err := url.ParseQuery(strings.TrimSpace(query))
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+
+	filter := &grafanaFilter{Metadata: url.Values{}}
+	for key, vals := range values {
+		if key == "event_type" {
+			for _, val := range vals {
+				for _, eventType := range strings.Split(val, ",") {
+					if eventType = strings.TrimSpace(eventType); len(eventType) > 0 {
+						filter.EventTypes = append(filter.EventTypes, eventType)
+					}
+				}
+			}
+			continue
+		}
+		filter.Metadata[strings.TrimPrefix(key, "metadata.")] = vals
+	}
+
+	return filter, nil
+}
+
+// Match reports whether the event's metadata has one of the required values at
+// every path in the filter.
+func (f *grafanaFilter) Match(event *Event) (bool, error) {
+	for path, vals := range f.Metadata {
+		value, err := metadataValue(event.Metadata, path)
+		if err != nil {
+			return false, err
+		}
+
+		found := false
+		for _, val := range vals {
+			if val == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// grafanaEvents returns up to maxEventsLimit events in the range that match the
+// filter, oldest first.
+func (s *server) grafanaEvents(ctx context.Context, rng GrafanaRange, filter *grafanaFilter) ([]Event, error) {
+	query := &EventsQuery{
+		EventTypes: filter.EventTypes,
+		Start:      rng.From,
+		End:        rng.To,
+		Order:      orderAscending,
+	}
+
+	events := []Event{}
+	err := s.store.Export(ctx, query, func(event *Event) error {
+		ok, err := filter.Match(event)
+		if err != nil || !ok {
+			return err
+		}
+
+		events = append(events, *event)
+		if len(events) >= maxEventsLimit {
+			return errGrafanaLimit
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errGrafanaLimit) {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// grafanaTags returns the event type and every top level metadata field with a
+// simple value as "key:value", sorted by key.
+func grafanaTags(event *Event) []string {
+	tags := []string{event.EventType}
+
+	b, err := json.Marshal(event.Metadata)
+	if err != nil {
+		return tags
+	}
+	metadata := map[string]interface{}{}
+	if err := json.Unmarshal(b, &metadata); err != nil {
+		return tags
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch value := metadata[key].(type) {
+		case string, float64, bool:
+			tags = append(tags, fmt.Sprintf("%s:%v", key, value))
+		}
+	}
+
+	return tags
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// GrafanaTestHandler lets Grafana check that the data source is reachable.
+func (s *server) GrafanaTestHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// GrafanaAnnotationsHandler returns events as annotations. The annotation
+// query is a filter as described by grafanaFilter.
+func (s *server) GrafanaAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
+	request := GrafanaAnnotationRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	filter, err := parseGrafanaFilter(request.Annotation.Query)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	events, err := s.grafanaEvents(r.Context(), request.Range, filter)
+	if err != nil {
+		respondWithJSON(
+			w,
+			http.StatusInternalServerError,
+			err,
+			"failed to read from database",
+			nil,
+		)
+		return
+	}
+
+	annotations := make([]GrafanaAnnotation, len(events))
+	for i := range events {
+		event := &events[i]
+		annotations[i] = GrafanaAnnotation{
+			Annotation: request.Annotation,
+			Time:       millis(event.StartTime),
+			Title:      event.EventType,
+			Text:       event.Notes,
+			Tags:       grafanaTags(event),
+		}
+		if event.EndTime.Valid {
+			annotations[i].TimeEnd = millis(event.EndTime.Time)
+			annotations[i].IsRegion = true
+		}
+	}
+
+	// Grafana expects a bare array rather than the usual envelope.
+	w.Header().Set(contentTypeHeader, applicationJSON)
+	json.NewEncoder(w).Encode(annotations)
+}
+
+// GrafanaSearchHandler lists the event types that can be used as query
+// targets.
+func (s *server) GrafanaSearchHandler(w http.ResponseWriter, r *http.Request) {
+	request := GrafanaSearchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	names := []string{}
+	for _, name := range s.eventTypes.Names() {
+		if strings.Contains(name, strings.ToUpper(request.Target)) {
+			names = append(names, name)
+		}
+	}
+
+	w.Header().Set(contentTypeHeader, applicationJSON)
+	json.NewEncoder(w).Encode(names)
+}
+
+// GrafanaQueryHandler returns the events of every target as a table, or as a
+// time series with a point of value 1 at the start of each event.
+func (s *server) GrafanaQueryHandler(w http.ResponseWriter, r *http.Request) {
+	request := GrafanaQueryRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	results := []interface{}{}
+	for _, target := range request.Targets {
+		filter := &grafanaFilter{EventTypes: []string{target.Target}}
+		if strings.Contains(target.Target, "=") {
+			var err error
+			if filter, err = parseGrafanaFilter(target.Target); err != nil {
+				respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+				return
+			}
+		}
+
+		events, err := s.grafanaEvents(r.Context(), request.Range, filter)
+		if err != nil {
+			respondWithJSON(
+				w,
+				http.StatusInternalServerError,
+				err,
+				"failed to read from database",
+				nil,
+			)
+			return
+		}
+
+		if target.Type == grafanaTargetTable {
+			table := grafanaTable{
+				Type: grafanaTargetTable,
+				Columns: []grafanaColumn{
+					{Text: "Time", Type: grafanaColumnTime},
+					{Text: "End", Type: grafanaColumnTime},
+					{Text: "Type", Type: grafanaColumnString},
+					{Text: "Notes", Type: grafanaColumnString},
+					{Text: "Tags", Type: grafanaColumnString},
+				},
+				Rows: [][]interface{}{},
+			}
+			for i := range events {
+				event := &events[i]
+				var end interface{}
+				if event.EndTime.Valid {
+					end = millis(event.EndTime.Time)
+				}
+				table.Rows = append(table.Rows, []interface{}{
+					millis(event.StartTime),
+					end,
+					event.EventType,
+					event.Notes,
+					strings.Join(grafanaTags(event), ", "),
+				})
+			}
+			results = append(results, table)
+			continue
+		}
+
+		series := grafanaTimeSeries{Target: target.Target, Datapoints: [][2]interface{}{}}
+		for i := range events {
+			series.Datapoints = append(series.Datapoints, [2]interface{}{1, millis(events[i].StartTime)})
+		}
+		results = append(results, series)
+	}
+
+	w.Header().Set(contentTypeHeader, applicationJSON)
+	json.NewEncoder(w).Encode(results)
+}
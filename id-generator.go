@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event IDs are Snowflake-style: 41 bits of milliseconds since idEpoch, then
+// 10 bits of node ID, then a 12 bit sequence number. IDs from one node are
+// strictly increasing and IDs from different nodes never collide, so they sort
+// by creation time and can be used as a pagination cursor.
+const (
+	idNodeBits     = 10
+	idSequenceBits = 12
+
+	maxNodeID   = 1<<idNodeBits - 1
+	maxSequence = 1<<idSequenceBits - 1
+)
+
+var idEpoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// eventIDs generates the ID of every new event. main replaces it once the node ID
+// is known.
+var eventIDs = NewIDGenerator(defaultNodeID())
+
+type IDGenerator struct {
+	mu       sync.Mutex
+	node     int64
+	lastMS   int64
+	sequence int64
+}
+
+func NewIDGenerator(node int64) *IDGenerator {
+	return &IDGenerator{node: node & maxNodeID}
+}
+
+// defaultNodeID derives a node ID from the host name and process ID. Replicas
+// should set --node-id explicitly to rule out collisions entirely.
+func defaultNodeID() int64 {
+	hostname, _ := os.Hostname()
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s:%d", hostname, os.Getpid())
+	return int64(h.Sum32()) & maxNodeID
+}
+
+// Next returns a new ID.
+func (g *IDGenerator) Next() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Never go backwards, even if the wall clock does.
+	ms := time.Since(idEpoch).Milliseconds()
+	if ms < g.lastMS {
+		ms = g.lastMS
+	}
+
+	if ms == g.lastMS {
+		g.sequence++
+		if g.sequence > maxSequence {
+			// Out of IDs for this millisecond, so borrow the next one.
+			ms++
+			g.sequence = 0
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastMS = ms
+
+	return ms<<(idNodeBits+idSequenceBits) | g.node<<idSequenceBits | g.sequence
+}
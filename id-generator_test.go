@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestIDGeneratorIsStrictlyIncreasing(t *testing.T) {
+	g := NewIDGenerator(1)
+
+	// More IDs than fit in one millisecond's sequence numbers.
+	last := int64(0)
+	for i := 0; i < 3*(maxSequence+1); i++ {
+		id := g.Next()
+		if id <= last {
+			t.Fatalf("ID %d after %d is not increasing", id, last)
+		}
+		last = id
+	}
+}
+
+func TestIDGeneratorNodes(t *testing.T) {
+	for _, node := range []int64{0, 1, maxNodeID} {
+		id := NewIDGenerator(node).Next()
+		if got := id >> idSequenceBits & maxNodeID; got != node {
+			t.Errorf("ID %d has node %d, want %d", id, got, node)
+		}
+	}
+
+	// Node IDs are truncated to the bits available for them.
+	if id := NewIDGenerator(maxNodeID + 2).Next(); id>>idSequenceBits&maxNodeID != 1 {
+		t.Errorf("ID %d doesn't have its node ID truncated to %d bits", id, idNodeBits)
+	}
+
+	// Generators on different nodes never produce the same ID.
+	a, b := NewIDGenerator(1), NewIDGenerator(2)
+	seen := map[int64]bool{}
+	for i := 0; i < 1000; i++ {
+		for _, id := range []int64{a.Next(), b.Next()} {
+			if seen[id] {
+				t.Fatalf("ID %d generated twice", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestEventIDIsEncodedAsString(t *testing.T) {
+	event := Event{ID: maxNodeID<<40 | 1}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := map[string]interface{}{}
+	if err := json.Unmarshal(b, &encoded); err != nil {
+		t.Fatal(err)
+	} else if encoded["ID"] != strconv.FormatInt(event.ID, 10) {
+		t.Errorf("got ID %#v, want the string %q", encoded["ID"], strconv.FormatInt(event.ID, 10))
+	}
+
+	decoded := Event{}
+	if err := json.Unmarshal(b, &decoded); err != nil || decoded.ID != event.ID {
+		t.Errorf("decoded ID %d, %v, want %d", decoded.ID, err, event.ID)
+	}
+}
+
+func TestEventIDIsDecodedFromStringOrNumber(t *testing.T) {
+	for _, test := range []struct {
+		json string
+		id   int64
+		ok   bool
+	}{
+		{`{"ID": "898812237745266688"}`, 898812237745266688, true},
+		{`{"ID": 898812237745266688}`, 898812237745266688, true},
+		{`{"ID": null}`, 0, true},
+		{`{"event_type": "PUSH"}`, 0, true},
+		{`{"ID": 1.5}`, 0, false},
+		{`{"ID": "x"}`, 0, false},
+	} {
+		event := Event{}
+		err := json.Unmarshal([]byte(test.json), &event)
+		if (err == nil) != test.ok || event.ID != test.id {
+			t.Errorf("%s: got ID %d, %v, want %d", test.json, event.ID, err, test.id)
+		}
+	}
+
+	// Events round-tripped by older clients are recorded again.
+	s := newTestServer(t)
+	expectStatus(t, s.serve(t, http.MethodPost, "/api/v0/record", map[string]interface{}{
+		"ID":         898812237745266688,
+		"event_type": eventTypeExperiment,
+		"notes":      "tried a new cache",
+	}, nil), http.StatusOK)
+}
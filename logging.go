@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+
+	redacted = "[REDACTED]"
+)
+
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
+// logLevel is shared by every logger so that it can be set from a flag.
+var logLevel = new(slog.LevelVar)
+
+// secretHeaders are request headers whose values are never logged.
+var secretHeaders = map[string]bool{
+	"Authorization":            true,
+	"Cookie":                   true,
+	signatureSHA1Header:        true,
+	signatureSHA256Header:      true,
+	slackSignatureSHA256Header: true,
+	gitlabTokenHeader:          true,
+}
+
+// secretFields are JSON and form fields whose values are never logged. They are
+// either credentials or data about the people using Slack.
+var secretFields = map[string]bool{
+	"token":        true,
+	"password":     true,
+	"secret":       true,
+	"response_url": true,
+	"trigger_id":   true,
+	"email":        true,
+	"real_name":    true,
+	"display_name": true,
+	"user_name":    true,
+	"phone":        true,
+}
+
+// isSecretField reports whether values under key must be redacted.
+func isSecretField(key string) bool {
+	key = strings.ToLower(key)
+	return secretFields[key] || strings.Contains(key, "token") || strings.Contains(key, "secret") || strings.Contains(key, "password")
+}
+
+// initLogging makes the default logger write JSON at the given level.
+func initLogging(level string) error {
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("log-level must be \"debug\", \"info\", \"warn\" or \"error\"")
+	}
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: logLevel,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if isSecretField(a.Key) {
+				return slog.String(a.Key, redacted)
+			}
+			return a
+		},
+	})))
+
+	return nil
+}
+
+// fatal logs an error and exits. It must only be used while starting up, never
+// while handling a request.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// logger returns the default logger with the request and trace IDs in ctx, if
+// there are any.
+func logger(ctx context.Context) *slog.Logger {
+	l := slog.Default()
+	if id, ok := ctx.Value(requestIDKey).(string); ok {
+		l = l.With("request_id", id)
+	}
+	if key, ok := apiKeyFromContext(ctx); ok {
+		l = l.With("api_key_id", key.ID)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		l = l.With("trace_id", spanContext.TraceID().String())
+	}
+	return l
+}
+
+// requestIDMiddleware gives every request an ID, reusing the caller's
+// X-Request-ID if there is one, and echoes it in the response.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if len(id) == 0 || len(id) > 128 {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// accessLogMiddleware logs every request once it has been handled.
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		logger(r.Context()).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+		)
+	})
+}
+
+// redactJSON replaces the values of secret fields anywhere in v.
+func redactJSON(v interface{}) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for key, value := range node {
+			if isSecretField(key) {
+				node[key] = redacted
+			} else {
+				node[key] = redactJSON(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range node {
+			node[i] = redactJSON(value)
+		}
+	}
+	return v
+}
+
+// redactBody returns a loggable form of a JSON or form encoded body with the
+// secret fields redacted. Other bodies are left out.
+func redactBody(contentType string, body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == applicationFormURLEncoded:
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			break
+		}
+		form := map[string]interface{}{}
+		for key := range values {
+			value := values.Get(key)
+			var v interface{}
+			if isSecretField(key) {
+				form[key] = redacted
+			} else if json.Unmarshal([]byte(value), &v) == nil && strings.HasPrefix(strings.TrimSpace(value), "{") {
+				// Slack interactions send a JSON document as the payload field.
+				form[key] = redactJSON(v)
+			} else {
+				form[key] = value
+			}
+		}
+		return form
+	case mediaType == applicationJSON || strings.HasSuffix(mediaType, "+json"):
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			break
+		}
+		return redactJSON(v)
+	}
+
+	return fmt.Sprintf("[%d bytes of %s]", len(body), mediaType)
+}
+
+// bodyLoggingMiddleware logs requests with their headers and bodies at debug
+// level, redacting secrets and personal data.
+func bodyLoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := logger(r.Context())
+		if !l.Enabled(r.Context(), slog.LevelDebug) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+			return
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+
+		headers := map[string]string{}
+		for name := range r.Header {
+			if secretHeaders[name] || isSecretField(name) {
+				headers[name] = redacted
+			} else {
+				headers[name] = r.Header.Get(name)
+			}
+		}
+
+		l.Debug("request body",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"headers", headers,
+			"body", redactBody(r.Header.Get(contentTypeHeader), body),
+		)
+
+		next.ServeHTTP(w, r)
+	})
+}
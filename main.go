@@ -4,37 +4,25 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
-	"math/rand"
+	"log/slog"
 	"net/http"
-	"net/http/httputil"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
 	"strconv"
+	"sync"
 	"time"
 
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/crypto/acme/autocert"
 	"makeshift.dev/event-tracker/slack"
-
-	// _ "github.com/mattn/go-sqlite3"
-	_ "github.com/go-sql-driver/mysql"
-)
-
-var (
-	isValidEventType = map[string]bool{
-		"DEPLOYMENT":   true,
-		"MERGE":        true,
-		"APP RELEASE":  true,
-		"EXPERIMENT":   true,
-		"OPS ACTIVITY": true,
-	}
 )
 
 const (
@@ -51,7 +39,11 @@ type Response struct {
 }
 
 type server struct {
-	db                 *sql.DB
+	store              EventStore
+	eventTypes         *EventTypeRegistry
+	Store              *string
+	SQLitePath         *string
+	DBHost             *string
 	DBUser             *string
 	DBPassword         *string
 	DBName             *string
@@ -64,7 +56,31 @@ type server struct {
 	router             *mux.Router
 	SlackClient        *slack.Client
 	SlackLogChannel    *string
+	OTLPEndpoint       *string
+	RequireAPIKey      *bool
 	Location           *time.Location
+	// GitHubWorkflows maps workflow names to the event type their runs are
+	// recorded as.
+	GitHubWorkflows map[string]string
+	// GitHubRepositorySecrets are the webhook secrets of repositories or
+	// organizations that do not use GitHubSecret.
+	GitHubRepositorySecrets map[string][]string
+	GitHubAllowSHA1         *bool
+	// GitLabToken is a comma separated list of GitLab webhook secret tokens, and
+	// GitLabPipelines GitHubWorkflows for GitLab pipelines.
+	GitLabToken     *string
+	GitLabPipelines map[string]string
+	// BitbucketSecret is a comma separated list of Bitbucket webhook secrets, and
+	// BitbucketBranches the branches whose pushes are recorded.
+	BitbucketSecret   *string
+	BitbucketBranches []string
+	// routingRules decide how GitHub webhooks are recorded, if configured.
+	routingRules *RoutingRules
+	// slackPosts tracks posts to Slack made after the response was sent, so that
+	// shutdown can wait for them.
+	slackPosts sync.WaitGroup
+
+	shutdownTracing func(context.Context) error
 }
 
 func respondWithJSON(w http.ResponseWriter,
@@ -90,68 +106,133 @@ func respondWithJSON(w http.ResponseWriter,
 	})
 
 	if err != nil {
-		log.Printf("Response status: %d error: %s\n", status, err.Error())
+		// The request ID header was set by requestIDMiddleware, which saves passing
+		// the request to every call.
+		level := slog.LevelWarn
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(context.Background(), level, "request failed",
+			"request_id", w.Header().Get(requestIDHeader),
+			"status", status,
+			"error", err.Error(),
+		)
 	}
 }
 
-func (s *server) initDB() {
-	var err error
-	s.db, err = sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(db:%d)/%s", *s.DBUser, *s.DBPassword, *s.DBPort, *s.DBName))
-	if err != nil {
-		log.Fatalln(err.Error())
-	}
-
-	statement := `
-CREATE TABLE IF NOT EXISTS events (
-	id BIGINT(20) UNSIGNED,
-	event_type VARCHAR(20) NOT NULL,
-	start_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-	end_time TIMESTAMP NULL DEFAULT NULL,
-	notes TEXT DEFAULT NULL,
-	metadata JSON DEFAULT NULL,
-	insert_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-	PRIMARY KEY (id)
-)
-`
-	if _, err := s.db.Exec(statement); err != nil {
-		log.Fatalln(err)
+// respondWithWriteError responds to a failure to record or update an event with
+// the status given by writeErrorStatus.
+func respondWithWriteError(w http.ResponseWriter, err error) {
+	switch status := writeErrorStatus(err); status {
+	case http.StatusBadRequest:
+		respondWithJSON(w, status, err, "", invalidEventData(err))
+	case http.StatusForbidden:
+		respondWithJSON(w, status, err, "", nil)
+	default:
+		respondWithJSON(
+			w,
+			status,
+			err,
+			"failed to write to database",
+			nil,
+		)
 	}
 }
 
-func verboseLoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if req, err := httputil.DumpRequest(r, true); err == nil {
-			log.Println(string(req))
-		} else {
-			log.Printf("Failed to dump request. error: %s\n", err.Error())
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
-func combinedLogginMiddleware(next http.Handler) http.Handler {
-	return handlers.CombinedLoggingHandler(os.Stdout, next)
+// writeErrorStatus returns the status for a failure to record or update an
+// event. An invalid event is a bad request, an event type that the API key may
+// not record is forbidden, and anything else is a database failure.
+func writeErrorStatus(err error) int {
+	if errors.As(err, &invalidEventError{}) {
+		return http.StatusBadRequest
+	} else if errors.Is(err, errEventTypeNotAllowed) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
 }
 
 func (s *server) initAPI() {
 	s.router = mux.NewRouter()
-	s.router.Use(combinedLogginMiddleware)
+	s.router.Use(requestIDMiddleware)
+	s.router.Use(tracingMiddleware)
+	s.router.Use(accessLogMiddleware)
+	s.router.Use(metricsMiddleware)
 
 	s.router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// This is used by the load balancer health check.
 		w.WriteHeader(http.StatusOK)
 	}).Methods(http.MethodGet)
 
+	s.router.Handle("/metrics", promhttp.Handler()).
+		Methods(http.MethodGet)
+
 	api := s.router.PathPrefix("/api").Subrouter()
 	apiV0 := api.PathPrefix("/v0").Subrouter()
+	apiV0.Use(s.apiKeyMiddleware)
 
 	// Add your routes as needed
 	apiV0.HandleFunc("/record", s.RecordHandler).
+		Methods(http.MethodPost).
+		HeadersRegexp(contentTypeHeader, "^("+applicationJSON+"|"+regexp.QuoteMeta(applicationCloudEvents)+")")
+	apiV0.HandleFunc("/record/batch", s.RecordBatchHandler).
+		Methods(http.MethodPost).
+		HeadersRegexp(contentTypeHeader, "^("+applicationJSON+"|"+applicationNDJSON+")")
+	apiV0.HandleFunc("/events", s.EventsHandler).
+		Methods(http.MethodGet)
+	apiV0.HandleFunc("/events/export", s.ExportEventsHandler).
+		Methods(http.MethodGet)
+	apiV0.HandleFunc("/events/{id:[0-9]+}", s.GetEventHandler).
+		Methods(http.MethodGet)
+	apiV0.HandleFunc("/events/{id:[0-9]+}", s.PatchEventHandler).
+		Methods(http.MethodPatch).
+		Headers(contentTypeHeader, applicationJSON)
+	apiV0.HandleFunc("/events/{id:[0-9]+}", s.DeleteEventHandler).
+		Methods(http.MethodDelete)
+	apiV0.HandleFunc("/event-types", s.ListEventTypesHandler).
+		Methods(http.MethodGet)
+	apiV0.HandleFunc("/event-types", s.CreateEventTypeHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON)
+	apiV0.HandleFunc("/event-types/{name}", s.GetEventTypeHandler).
+		Methods(http.MethodGet)
+	apiV0.HandleFunc("/event-types/{name}", s.UpdateEventTypeHandler).
+		Methods(http.MethodPut).
+		Headers(contentTypeHeader, applicationJSON)
+	apiV0.HandleFunc("/event-types/{name}", s.DeleteEventTypeHandler).
+		Methods(http.MethodDelete)
+	apiV0.HandleFunc("/api-keys", s.ListAPIKeysHandler).
+		Methods(http.MethodGet)
+	apiV0.HandleFunc("/api-keys", s.CreateAPIKeyHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON)
+	apiV0.HandleFunc("/api-keys/{id}", s.RevokeAPIKeyHandler).
+		Methods(http.MethodDelete)
+
+	// Grafana JSON data source, for overlaying events on graphs
+	grafanaAPI := apiV0.PathPrefix("/grafana").Subrouter()
+	grafanaAPI.HandleFunc("", s.GrafanaTestHandler).
+		Methods(http.MethodGet)
+	grafanaAPI.HandleFunc("/", s.GrafanaTestHandler).
+		Methods(http.MethodGet)
+	grafanaAPI.HandleFunc("/annotations", s.GrafanaAnnotationsHandler).
+		Methods(http.MethodPost)
+	grafanaAPI.HandleFunc("/search", s.GrafanaSearchHandler).
+		Methods(http.MethodPost)
+	grafanaAPI.HandleFunc("/query", s.GrafanaQueryHandler).
+		Methods(http.MethodPost)
+
+	// Ingest routes that mimic other tools' APIs, so that anything able to post to
+	// them can record events by changing only its base URL
+	compatAPI := apiV0.PathPrefix("/compat").Subrouter()
+	compatAPI.HandleFunc("/grafana/api/annotations", s.GrafanaAnnotationPostHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON)
+	compatAPI.HandleFunc("/datadog/api/v1/events", s.DatadogEventPostHandler).
 		Methods(http.MethodPost).
 		Headers(contentTypeHeader, applicationJSON)
 
 	// GitHub Webhook handler
-	githubValidator := GitHubWebHookValidator{Secret: []byte(*s.GitHubSecret)}
+	githubValidator := NewGitHubWebHookValidator(splitList(*s.GitHubSecret), s.GitHubRepositorySecrets, *s.GitHubAllowSHA1)
 	githubAPI := apiV0.PathPrefix("/github").Subrouter()
 	githubAPI.Use(githubValidator.Middleware)
 	githubAPI.HandleFunc("", s.PullRequestHandler).
@@ -162,6 +243,30 @@ func (s *server) initAPI() {
 		Methods(http.MethodPost).
 		Headers(contentTypeHeader, applicationJSON).
 		Headers(githubEventHeader, pushEvent)
+	githubAPI.HandleFunc("", s.ReleaseHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON).
+		Headers(githubEventHeader, releaseEvent)
+	githubAPI.HandleFunc("", s.TagHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON).
+		HeadersRegexp(githubEventHeader, "^("+createEvent+"|"+deleteEvent+")$")
+	githubAPI.HandleFunc("", s.RegistryPackageHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON).
+		Headers(githubEventHeader, registryPackageEvent)
+	githubAPI.HandleFunc("", s.DeploymentHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON).
+		Headers(githubEventHeader, deploymentEvent)
+	githubAPI.HandleFunc("", s.DeploymentStatusHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON).
+		Headers(githubEventHeader, deploymentStatusEvent)
+	githubAPI.HandleFunc("", s.WorkflowRunHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON).
+		Headers(githubEventHeader, workflowRunEvent)
 
 	githubAPI.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
 		eventType := r.Header.Get(githubEventHeader)
@@ -170,10 +275,67 @@ func (s *server) initAPI() {
 		Methods(http.MethodPost).
 		Headers(contentTypeHeader, applicationJSON)
 
+	// GitLab Webhook handler
+	gitlabValidator := GitLabWebHookValidator{Tokens: toBytes(splitList(*s.GitLabToken))}
+	gitlabAPI := apiV0.PathPrefix("/gitlab").Subrouter()
+	gitlabAPI.Use(gitlabValidator.Middleware)
+	gitlabAPI.HandleFunc("", s.GitLabPushHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON).
+		Headers(gitlabEventHeader, gitlabPushHook)
+	gitlabAPI.HandleFunc("", s.GitLabTagPushHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON).
+		Headers(gitlabEventHeader, gitlabTagPushHook)
+	gitlabAPI.HandleFunc("", s.GitLabMergeRequestHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON).
+		Headers(gitlabEventHeader, gitlabMergeRequestHook)
+	gitlabAPI.HandleFunc("", s.GitLabPipelineHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON).
+		Headers(gitlabEventHeader, gitlabPipelineHook)
+	gitlabAPI.HandleFunc("", s.GitLabDeploymentHandler).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON).
+		Headers(gitlabEventHeader, gitlabDeploymentHook)
+
+	gitlabAPI.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
+		eventType := r.Header.Get(gitlabEventHeader)
+		respondWithJSON(w, http.StatusOK, nil, fmt.Sprintf("GitLab event '%s' not yet handled", eventType), nil)
+	}).
+		Methods(http.MethodPost).
+		Headers(contentTypeHeader, applicationJSON)
+
+	// Bitbucket Webhook handler. Bitbucket Server adds a charset to the content
+	// type.
+	bitbucketValidator := BitbucketWebHookValidator{Secrets: toBytes(splitList(*s.BitbucketSecret))}
+	bitbucketAPI := apiV0.PathPrefix("/bitbucket").Subrouter()
+	bitbucketAPI.Use(bitbucketValidator.Middleware)
+	bitbucketAPI.HandleFunc("", s.BitbucketPushHandler).
+		Methods(http.MethodPost).
+		HeadersRegexp(contentTypeHeader, "^"+applicationJSON).
+		Headers(bitbucketEventHeader, bitbucketPushEvent)
+	bitbucketAPI.HandleFunc("", s.BitbucketPullRequestHandler).
+		Methods(http.MethodPost).
+		HeadersRegexp(contentTypeHeader, "^"+applicationJSON).
+		Headers(bitbucketEventHeader, bitbucketPullRequestMergedEvent)
+	bitbucketAPI.HandleFunc("", s.BitbucketRefsChangedHandler).
+		Methods(http.MethodPost).
+		HeadersRegexp(contentTypeHeader, "^"+applicationJSON).
+		Headers(bitbucketEventHeader, bitbucketServerRefsChangedEvent)
+
+	bitbucketAPI.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
+		eventType := r.Header.Get(bitbucketEventHeader)
+		respondWithJSON(w, http.StatusOK, nil, fmt.Sprintf("Bitbucket event '%s' not yet handled", eventType), nil)
+	}).
+		Methods(http.MethodPost).
+		HeadersRegexp(contentTypeHeader, "^"+applicationJSON)
+
 	// Slack slash-command handler
 	slackValidator := SlackRequestValidator{Secret: []byte(*s.SlackSigningSecret)}
 	slackAPI := apiV0.PathPrefix("/slack").Subrouter()
-	slackAPI.Use(verboseLoggingMiddleware)
+	slackAPI.Use(bodyLoggingMiddleware)
 	slackAPI.Use(slackValidator.Middleware)
 	slackAPI.HandleFunc("/command", s.SlackCommandHandler).
 		Methods(http.MethodPost).
@@ -185,9 +347,10 @@ func (s *server) initAPI() {
 }
 
 func (s *server) ServeHTTPOnly() {
-	log.Println("serving HTTP only")
-	s.initDB()
-	defer s.db.Close()
+	slog.Info("serving HTTP only")
+	s.initStore()
+	defer s.store.Close()
+	s.shutdownTracing = initTracing(*s.OTLPEndpoint)
 	s.initAPI()
 
 	httpServer := &http.Server{
@@ -196,12 +359,12 @@ func (s *server) ServeHTTPOnly() {
 	}
 
 	go func() {
-		if err := httpServer.ListenAndServe(); err != nil {
-			log.Fatalln(err)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("failed to serve HTTP", "error", err.Error())
 		}
 	}()
 
-	log.Println("server ready")
+	slog.Info("server ready")
 
 	c := make(chan os.Signal, 1)
 
@@ -222,14 +385,17 @@ func (s *server) ServeHTTPOnly() {
 
 	<-ctx.Done()
 
-	log.Println("shutting down")
+	slog.Info("shutting down")
+	s.slackPosts.Wait()
+	s.flushTraces()
 	os.Exit(0)
 }
 
 func (s *server) ServeHTTPAndHTTPS() {
-	log.Println("serving HTTP only")
-	s.initDB()
-	defer s.db.Close()
+	slog.Info("serving HTTP and HTTPS")
+	s.initStore()
+	defer s.store.Close()
+	s.shutdownTracing = initTracing(*s.OTLPEndpoint)
 	s.initAPI()
 
 	httpServer := &http.Server{
@@ -243,18 +409,18 @@ func (s *server) ServeHTTPAndHTTPS() {
 	}
 
 	go func() {
-		if err := httpServer.ListenAndServe(); err != nil {
-			log.Fatalln(err)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("failed to serve HTTP", "error", err.Error())
 		}
 	}()
 
 	go func() {
-		if err := httpsServer.ListenAndServe(); err != nil {
-			log.Fatalln(err)
+		if err := httpsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("failed to serve HTTPS", "error", err.Error())
 		}
 	}()
 
-	log.Println("server ready")
+	slog.Info("server ready")
 
 	c := make(chan os.Signal, 1)
 
@@ -276,23 +442,26 @@ func (s *server) ServeHTTPAndHTTPS() {
 
 	<-ctx.Done()
 
-	log.Println("shutting down")
+	slog.Info("shutting down")
+	s.slackPosts.Wait()
+	s.flushTraces()
 	os.Exit(0)
 }
 
 func (s *server) ServeWithAutocert() {
-	log.Println("serving HTTPS using autocert")
-	s.initDB()
-	defer s.db.Close()
+	slog.Info("serving HTTPS using autocert")
+	s.initStore()
+	defer s.store.Close()
+	s.shutdownTracing = initTracing(*s.OTLPEndpoint)
 	s.initAPI()
 
 	cacheDir := filepath.Join("/tmp/cert", *s.Domain)
 	if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
 		if err := os.Mkdir(cacheDir, 0777); err != nil {
-			log.Fatalln(err)
+			fatal("failed to create certificate cache", "path", cacheDir, "error", err.Error())
 		}
 	} else if err := os.Chmod(cacheDir, 0777); err != nil {
-		log.Fatalln(err)
+		fatal("failed to make certificate cache writable", "path", cacheDir, "error", err.Error())
 	}
 
 	certManager := autocert.Manager{
@@ -331,18 +500,18 @@ func (s *server) ServeWithAutocert() {
 	}
 
 	go func() {
-		if err := httpServer.ListenAndServe(); err != nil {
-			log.Fatalln(err)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("failed to serve HTTP", "error", err.Error())
 		}
 	}()
 
 	go func() {
-		if err := httpsServer.ListenAndServeTLS("", ""); err != nil {
-			log.Fatalln(err)
+		if err := httpsServer.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("failed to serve HTTPS", "error", err.Error())
 		}
 	}()
 
-	log.Println("server ready")
+	slog.Info("server ready")
 
 	c := make(chan os.Signal, 1)
 
@@ -364,72 +533,117 @@ func (s *server) ServeWithAutocert() {
 
 	<-ctx.Done()
 
-	log.Println("shutting down")
+	slog.Info("shutting down")
+	s.slackPosts.Wait()
+	s.flushTraces()
 	os.Exit(0)
 }
 
-func (s *server) writeToDBAndLog(ctx context.Context, event *Event) error {
-	if err := event.ValidateAndRectify(); err != nil {
-		return err
-	}
+func (s *server) writeToDBAndLog(ctx context.Context, event *Event) (err error) {
+	ctx, span := tracer.Start(ctx, "writeToDBAndLog")
+	defer func() { endSpan(span, err) }()
 
-	metadata, err := json.Marshal(event.Metadata)
-	if err != nil {
-		return fmt.Errorf("failed to marshal metadata to []byte")
+	if err := event.ValidateAndRectify(s.eventTypes); err != nil {
+		return invalidEventError{err}
+	} else if err := authorizeEvent(ctx, event); err != nil {
+		return err
 	}
+	addTraceID(ctx, event)
+	span.SetAttributes(
+		attribute.String("event.type", event.EventType),
+		attribute.Int64("event.id", event.ID),
+	)
 
 	if !event.DryRun {
-		_, err := s.db.ExecContext(ctx, `
-INSERT INTO events (
-	id,
-	event_type,
-	start_time,
-	end_time,
-	notes,
-	metadata
-) VALUES (
-	?,
-	?,
-	?,
-	?,
-	?,
-	?
-)
-`, event.ID, event.EventType, event.StartTime, event.EndTime, event.Notes, metadata)
-		if err != nil {
+		if replayed, err := s.replay(ctx, event); err != nil || replayed {
 			return err
 		}
-		if err := s.logToSlackChannel(event); err != nil {
+		if err := s.insertEvent(ctx, event); errors.Is(err, errDuplicateKey) {
+			// A concurrent retry of the same request got there first.
+			if replayed, err := s.replay(ctx, event); err != nil {
+				return err
+			} else if !replayed {
+				return errOrphanedKey
+			}
+			return nil
+		} else if err != nil {
 			return err
 		}
+		observeEventWritten(event)
+		// The event is stored, so the request succeeded even if Slack fails.
+		// logToSlackChannel logs and counts its own failures.
+		s.logToSlackChannel(ctx, event)
 	} else {
+		metadata, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metadata to []byte")
+		}
+
 		endTimeBytes, _ := event.EndTime.MarshalJSON()
 		endTime, _ := strconv.Unquote(string(endTimeBytes))
 
-		log.Printf(`
-INSERT INTO events (
-	id,
-	event_type,
-	start_time,
-	end_time,
-	notes,
-	metadata
-) VALUES (
-	%d,
-	'%s',
-	'%s',
-	'%s',
-	'%s',
-	'%s'
-)
-`, event.ID, event.EventType, event.StartTime.Format(time.RFC3339), endTime, event.Notes, metadata)
+		logger(ctx).Info("dry run",
+			"id", event.ID,
+			"event_type", event.EventType,
+			"start_time", event.StartTime.Format(time.RFC3339),
+			"end_time", endTime,
+			"notes", event.Notes,
+			"metadata", json.RawMessage(metadata),
+		)
 	}
 
 	return nil
 }
 
-func (s *server) logToSlackChannel(event *Event) error {
-	if len(*s.SlackLogChannel) == 0 {
+// replay replaces the event with the one already created by an earlier delivery
+// of the same request, if there is one.
+func (s *server) replay(ctx context.Context, event *Event) (bool, error) {
+	if len(event.IdempotencyKey) == 0 {
+		return false, nil
+	}
+
+	existing, err := s.store.GetByIdempotencyKey(ctx, event.IdempotencyKey)
+	if errors.Is(err, errEventNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	*event = *existing
+	return true, nil
+}
+
+// insertEvent retries with a fresh ID in the unlikely case that the event's ID is
+// already taken, e.g. by a replica that was misconfigured with the same node ID.
+func (s *server) insertEvent(ctx context.Context, event *Event) error {
+	const maxAttempts = 3
+
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			logger(ctx).Warn("event id already exists, retrying with a new id", "id", event.ID)
+			event.ID = eventIDs.Next()
+		}
+		if err = s.store.Insert(ctx, event); !errors.Is(err, errDuplicateEventID) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (s *server) logToSlackChannel(ctx context.Context, event *Event) (err error) {
+	ctx, span := tracer.Start(ctx, "logToSlackChannel")
+	defer func() { endSpan(span, err) }()
+
+	channel := *s.SlackLogChannel
+	if len(event.slackChannel) > 0 {
+		channel = event.slackChannel
+	} else if eventType, ok := s.eventTypes.Lookup(event.EventType); ok && len(eventType.SlackChannel) > 0 {
+		channel = eventType.SlackChannel
+	}
+
+	if len(channel) == 0 {
 		return nil
 	}
 
@@ -439,63 +653,119 @@ func (s *server) logToSlackChannel(event *Event) error {
 	// if the Metadata field is a struct!!
 	// Make it an interface, and it just works.
 	// idk dude.
+	defer func() {
+		if err != nil {
+			logger(ctx).Error("failed to log event to Slack channel", "id", event.ID, "channel", channel, "error", err.Error())
+			slackPostFailuresTotal.Inc()
+		}
+	}()
+
 	metadataBytes, err := json.Marshal(event.Metadata)
 	if err != nil {
-		log.Printf("Failed to log event to Slack channel with error: %s\n", err.Error())
 		return err
 	}
 
-	metadata := new(interface{})
-	if err := json.Unmarshal(metadataBytes, metadata); err != nil {
-		log.Fatal(err.Error())
+	var metadata interface{}
+	if err := json.Unmarshal(metadataBytes, &metadata); err != nil {
+		return err
 	}
 	event.Metadata = metadata
 
-	request := slack.NewChatPostMessageRequest(*s.SlackLogChannel)
+	request := slack.NewChatPostMessageRequest(channel)
 	buffer := &bytes.Buffer{}
-	if err := slackTemplate.Execute(buffer, event); err != nil {
-		log.Printf("Failed to log event to Slack channel with error: %s\n", err.Error())
+	if err := slackTemplate.ExecuteTemplate(buffer, slackTemplateName(event), event); err != nil {
 		return err
 	}
 
 	request.Text = buffer.String()
-	if _, err := s.SlackClient.ChatPostMessage(request); err != nil {
-		log.Printf("Failed to log event to Slack channel with error: %s\n", err.Error())
+	if _, err := s.SlackClient.ChatPostMessageContext(ctx, request); err != nil {
 		return err
 	}
 
 	return nil
 }
-func init() {
-	rand.Seed(time.Now().Unix())
-}
 
 func main() {
+	cfg, args, err := loadConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		fatal("invalid configuration", "error", err.Error())
+	}
+
+	if len(args) > 0 && args[0] == "config" {
+		if len(args) < 2 || args[1] != "print" {
+			fatal("usage: config print")
+		}
+		if err := cfg.Print(os.Stdout); err != nil {
+			fatal("failed to print configuration", "error", err.Error())
+		}
+		if err := cfg.Validate(); err != nil {
+			fatal("invalid configuration", "error", err.Error())
+		}
+		return
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fatal("invalid configuration", "error", err.Error())
+	}
+	if err := initLogging(cfg.LogLevel); err != nil {
+		fatal(err.Error())
+	}
+
 	s := server{}
-	s.Domain = flag.String("domain", "www.makeshift.dev", "domain for which a certificate should be obtained")
-	s.DBUser = flag.String("db-user", "user", "username for database access")
-	s.DBPassword = flag.String("db-password", "password", "password for database access")
-	s.DBName = flag.String("db-name", "test", "name of database")
-	s.GitHubSecret = flag.String("github-secret", "secret", "github webhook secret")
-	s.SlackSigningSecret = flag.String("slack-signing-secret", "secret", "slack signing secret")
-	slackOauthToken := flag.String("slack-oauth-token", "secret", "slack oath token")
-	s.SlackLogChannel = flag.String("slack-log-channel", "channel", "slack log channel")
-	s.DBPort = flag.Int("db-port", 3306, "database port number")
-	s.HTTPPort = flag.Int("http-port", 80, "port on which HTTP should be served")
-	s.HTTPSPort = flag.Int("https-port", 443, "port on which HTTPS should be served")
-	useAutocert := flag.String("use-autocert", "false", "specify \"true\" or \"false\" to serve HTTPS using autocert")
-	timeZone := flag.String("time-zone", "America/New_York", "time zone to use when logging to various sources")
-	flag.Parse()
-
-	location, err := time.LoadLocation(*timeZone)
-	if err != nil {
-		log.Fatalf("failed to load time zone with error: %s", err.Error())
+	s.Domain = &cfg.Domain
+	s.Store = &cfg.Store
+	s.SQLitePath = &cfg.SQLitePath
+	s.DBHost = &cfg.DBHost
+	s.DBUser = &cfg.DBUser
+	s.DBPassword = &cfg.DBPassword
+	s.DBName = &cfg.DBName
+	s.DBPort = &cfg.DBPort
+	s.HTTPPort = &cfg.HTTPPort
+	s.HTTPSPort = &cfg.HTTPSPort
+	s.GitHubSecret = &cfg.GitHubSecret
+	s.SlackSigningSecret = &cfg.SlackSigningSecret
+	s.SlackLogChannel = &cfg.SlackLogChannel
+	s.OTLPEndpoint = &cfg.OTLPEndpoint
+	s.GitHubWorkflows = cfg.GitHubWorkflows()
+	s.GitHubRepositorySecrets = cfg.GitHubRepositorySecrets
+	s.GitHubAllowSHA1 = &cfg.GitHubAllowSHA1
+	s.GitLabToken = &cfg.GitLabToken
+	s.GitLabPipelines = cfg.GitLabPipelines()
+	s.BitbucketSecret = &cfg.BitbucketSecret
+	s.BitbucketBranches = splitList(cfg.BitbucketBranches)
+	if len(cfg.GitHubRoutingRules) > 0 {
+		if s.routingRules, err = LoadRoutingRules(cfg.GitHubRoutingRules); err != nil {
+			fatal("failed to load routing rules", "error", err.Error())
+		}
+	}
+	s.RequireAPIKey = &cfg.RequireAPIKey
+
+	if cfg.NodeID >= 0 {
+		eventIDs = NewIDGenerator(cfg.NodeID)
+	}
+	for _, service := range splitList(cfg.MetricsServices) {
+		metricsServices[service] = true
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		var action string
+		if len(args) > 1 {
+			action = args[1]
+		}
+		s.Migrate(action)
+		return
+	} else if len(args) > 0 && args[0] == "api-keys" {
+		s.APIKeys(args[1:])
+		return
 	}
 
-	s.Location = location
-	s.SlackClient = slack.New(*slackOauthToken)
+	// The time zone was checked by Validate.
+	s.Location, _ = time.LoadLocation(cfg.TimeZone)
+	s.SlackClient = slack.New(cfg.SlackOAuthToken)
 
-	if *useAutocert == "true" {
+	if cfg.UseAutocert {
 		s.ServeWithAutocert()
 	} else {
 		s.ServeHTTPAndHTTPS()
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"makeshift.dev/event-tracker/slack"
+)
+
+const (
+	testGitHubSecret    = "github-secret"
+	testGitLabToken     = "gitlab-token"
+	testBitbucketSecret = "bitbucket-secret"
+)
+
+// newTestServer returns a server backed by the memory store, with test secrets
+// for the webhooks, no API keys required and Slack turned off.
+func newTestServer(t *testing.T) *server {
+	t.Helper()
+
+	cfg := defaultConfig()
+	cfg.Store = storeMemory
+	cfg.GitHubSecret = testGitHubSecret
+	cfg.GitLabToken = testGitLabToken
+	cfg.BitbucketSecret = testBitbucketSecret
+	cfg.SlackLogChannel = ""
+	cfg.RequireAPIKey = false
+
+	s := &server{
+		store:              NewMemoryEventStore(),
+		Store:              &cfg.Store,
+		GitHubSecret:       &cfg.GitHubSecret,
+		SlackSigningSecret: &cfg.SlackSigningSecret,
+		SlackLogChannel:    &cfg.SlackLogChannel,
+		OTLPEndpoint:       &cfg.OTLPEndpoint,
+		RequireAPIKey:      &cfg.RequireAPIKey,
+		GitHubWorkflows:    cfg.GitHubWorkflows(),
+		GitHubAllowSHA1:    &cfg.GitHubAllowSHA1,
+		GitLabToken:        &cfg.GitLabToken,
+		GitLabPipelines:    cfg.GitLabPipelines(),
+		BitbucketSecret:    &cfg.BitbucketSecret,
+		BitbucketBranches:  splitList(cfg.BitbucketBranches),
+	}
+	s.eventTypes = NewEventTypeRegistry(s.store)
+	if err := s.eventTypes.Refresh(context.Background()); err != nil {
+		t.Fatalf("failed to load event types: %v", err)
+	}
+	s.initAPI()
+
+	return s
+}
+
+// fakeSlack answers the Slack client's requests in place of the Slack API and
+// keeps the messages posted.
+type fakeSlack struct {
+	mu       sync.Mutex
+	messages []slack.ChatPostMessageRequest
+	// fail makes every request fail as if Slack were down.
+	fail bool
+}
+
+// useFakeSlack makes the server log events to the channel through a fakeSlack,
+// which is the transport of the server's Slack client.
+func useFakeSlack(t *testing.T, s *server, channel string) *fakeSlack {
+	t.Helper()
+
+	f := &fakeSlack{}
+	s.SlackClient = slack.NewWithHTTPClient("xoxb-test", &http.Client{Transport: f})
+	*s.SlackLogChannel = channel
+
+	return f
+}
+
+func (f *fakeSlack) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status, body := http.StatusOK, `{"ok": true}`
+	if f.fail {
+		status, body = http.StatusServiceUnavailable, ""
+	} else if r.URL.Path == "/api"+slack.MethodChatPostMessage.String() {
+		message := slack.ChatPostMessageRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			return nil, err
+		}
+		f.messages = append(f.messages, message)
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+// posted returns the text of the messages posted so far.
+func (f *fakeSlack) posted() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	texts := []string{}
+	for _, message := range f.messages {
+		texts = append(texts, message.Text)
+	}
+	return texts
+}
+
+// serve sends a request with a JSON body, unless body is nil, through the
+// server's router.
+func (s *server) serve(t *testing.T, method, path string, body interface{}, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var b []byte
+	switch body := body.(type) {
+	case nil:
+	case []byte:
+		b = body
+	case string:
+		b = []byte(body)
+	default:
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			t.Fatalf("failed to marshal request body: %v", err)
+		}
+	}
+
+	r := httptest.NewRequest(method, path, bytes.NewReader(b))
+	if body != nil {
+		r.Header.Set(contentTypeHeader, applicationJSON)
+	}
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	return w
+}
+
+// serveGitHub sends a GitHub webhook signed with testGitHubSecret.
+func (s *server) serveGitHub(t *testing.T, event, delivery string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("failed to marshal webhook: %v", err)
+	}
+	mac := hmac.New(sha256.New, []byte(testGitHubSecret))
+	mac.Write(b)
+
+	return s.serve(t, http.MethodPost, "/api/v0/github", b, map[string]string{
+		githubEventHeader:     event,
+		githubDeliverHeader:   delivery,
+		signatureSHA256Header: "sha256=" + hex.EncodeToString(mac.Sum(nil)),
+	})
+}
+
+// decodeResponse decodes the response envelope, and its data into data unless
+// data is nil.
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, data interface{}) Response {
+	t.Helper()
+
+	var response struct {
+		Response
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response %q: %v", w.Body.String(), err)
+	}
+	if data != nil {
+		if err := json.Unmarshal(response.Data, data); err != nil {
+			t.Fatalf("failed to decode response data %q: %v", response.Data, err)
+		}
+	}
+
+	return response.Response
+}
+
+// expectStatus fails the test unless the response has the status.
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("got status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+}
+
+// storedEvents returns every event in the server's store, oldest first.
+func storedEvents(t *testing.T, s *server) []Event {
+	t.Helper()
+
+	q := &EventsQuery{}
+	if err := q.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	q.Order = orderAscending
+
+	events := []Event{}
+	if err := s.store.Export(context.Background(), q, func(event *Event) error {
+		events = append(events, *event)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return events
+}
+
+// metadataOf decodes the metadata of an event read from the store.
+func metadataOf(t *testing.T, event Event) map[string]interface{} {
+	t.Helper()
+
+	metadata := map[string]interface{}{}
+	raw, ok := event.Metadata.(json.RawMessage)
+	if !ok {
+		t.Fatalf("metadata of event %d is %T, not json.RawMessage", event.ID, event.Metadata)
+	}
+	if err := json.Unmarshal(raw, &metadata); err != nil {
+		t.Fatalf("metadata of event %d is not an object: %v", event.ID, err)
+	}
+
+	return metadata
+}
+
+func TestRecordHandler(t *testing.T) {
+	s := newTestServer(t)
+
+	w := s.serve(t, http.MethodPost, "/api/v0/record", map[string]interface{}{
+		"event_type": eventTypeExperiment,
+		"notes":      "try the new cache",
+		"metadata":   map[string]interface{}{"service": "api"},
+	}, nil)
+	expectStatus(t, w, http.StatusOK)
+
+	recorded := Event{}
+	decodeResponse(t, w, &recorded)
+
+	w = s.serve(t, http.MethodGet, "/api/v0/events/"+strconv.FormatInt(recorded.ID, 10), nil, nil)
+	expectStatus(t, w, http.StatusOK)
+	got := Event{}
+	decodeResponse(t, w, &got)
+	if got.Notes != "try the new cache" || got.EventType != eventTypeExperiment {
+		t.Errorf("got %+v, want the recorded event", got)
+	}
+}
+
+func TestRecordHandlerIdempotencyKey(t *testing.T) {
+	s := newTestServer(t)
+	headers := map[string]string{idempotencyKeyHeader: "abc"}
+	body := map[string]interface{}{"event_type": eventTypeExperiment, "notes": "once"}
+
+	first, second := Event{}, Event{}
+	w := s.serve(t, http.MethodPost, "/api/v0/record", body, headers)
+	expectStatus(t, w, http.StatusOK)
+	decodeResponse(t, w, &first)
+	w = s.serve(t, http.MethodPost, "/api/v0/record", body, headers)
+	expectStatus(t, w, http.StatusOK)
+	decodeResponse(t, w, &second)
+
+	if first.ID != second.ID {
+		t.Errorf("retry recorded event %d, want %d", second.ID, first.ID)
+	} else if events := storedEvents(t, s); len(events) != 1 {
+		t.Errorf("got %d events, want 1", len(events))
+	}
+}
+
+func TestRecordHandlerScopesIdempotencyKeyToAPIKey(t *testing.T) {
+	s := newTestServer(t)
+	*s.RequireAPIKey = true
+
+	var ids []int64
+	for i, auth := range []map[string]string{
+		createTestAPIKey(t, s, scopeEventsWrite),
+		createTestAPIKey(t, s, scopeEventsWrite),
+	} {
+		auth[idempotencyKeyHeader] = "abc"
+		w := s.serve(t, http.MethodPost, "/api/v0/record", map[string]interface{}{
+			"event_type": eventTypeExperiment,
+			"notes":      "client " + strconv.Itoa(i),
+		}, auth)
+		expectStatus(t, w, http.StatusOK)
+		got := Event{}
+		decodeResponse(t, w, &got)
+		ids = append(ids, got.ID)
+	}
+
+	if ids[0] == ids[1] {
+		t.Errorf("the second client got the first client's event %d", ids[0])
+	} else if events := storedEvents(t, s); len(events) != 2 {
+		t.Errorf("got %d events, want one for each client", len(events))
+	}
+}
+
+func TestRecordHandlerRejectsLongIdempotencyKeys(t *testing.T) {
+	s := newTestServer(t)
+	body := map[string]interface{}{"event_type": eventTypeExperiment, "notes": "x"}
+
+	expectStatus(t, s.serve(t, http.MethodPost, "/api/v0/record", body, map[string]string{
+		idempotencyKeyHeader: strings.Repeat("k", maxIdempotencyKeyLength+1),
+	}), http.StatusBadRequest)
+	expectStatus(t, s.serve(t, http.MethodPost, "/api/v0/compat/grafana/api/annotations", map[string]interface{}{"text": "x"}, map[string]string{
+		idempotencyKeyHeader: strings.Repeat("k", maxIdempotencyKeyLength+1),
+	}), http.StatusBadRequest)
+	expectStatus(t, s.serve(t, http.MethodPost, "/api/v0/record", body, map[string]string{
+		idempotencyKeyHeader: strings.Repeat("k", maxIdempotencyKeyLength),
+	}), http.StatusOK)
+
+	if events := storedEvents(t, s); len(events) != 1 {
+		t.Errorf("got %d events, want only the one with a short enough key", len(events))
+	}
+}
+
+func TestRecordHandlerRejectsInvalidEvents(t *testing.T) {
+	s := newTestServer(t)
+
+	for name, body := range map[string]interface{}{
+		"no notes":         map[string]interface{}{"event_type": eventTypeExperiment},
+		"unknown type":     map[string]interface{}{"event_type": "NOPE", "notes": "x"},
+		"invalid metadata": map[string]interface{}{"event_type": eventTypeDeployment, "notes": "x", "metadata": map[string]interface{}{"type": "WEBSRV"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expectStatus(t, s.serve(t, http.MethodPost, "/api/v0/record", body, nil), http.StatusBadRequest)
+		})
+	}
+
+	if events := storedEvents(t, s); len(events) != 0 {
+		t.Errorf("got %d events, want none", len(events))
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryEventStore keeps events in memory. It is meant for tests and for trying
+// out the server; everything is lost on restart.
+type memoryEventStore struct {
+	mu      sync.RWMutex
+	events  map[int64]*Event
+	deleted map[int64]time.Time
+	keys    map[string]int64
+	types   map[string]EventType
+	apiKeys map[string]*APIKey
+}
+
+func NewMemoryEventStore() EventStore {
+	s := &memoryEventStore{
+		events:  map[int64]*Event{},
+		deleted: map[int64]time.Time{},
+		keys:    map[string]int64{},
+		types:   map[string]EventType{},
+		apiKeys: map[string]*APIKey{},
+	}
+	for _, eventType := range defaultEventTypes {
+		s.types[eventType.Name] = eventType
+	}
+
+	return s
+}
+
+// copyEvent returns a copy of the event with its metadata normalized to
+// json.RawMessage, matching what the SQL stores return.
+func copyEvent(event *Event) (*Event, error) {
+	metadata, err := json.Marshal(event.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata to []byte")
+	}
+
+	c := *event
+	c.Metadata = json.RawMessage(metadata)
+	c.DryRun = false
+	c.IdempotencyKey = ""
+	return &c, nil
+}
+
+func (s *memoryEventStore) Insert(ctx context.Context, event *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(event); err != nil {
+		return err
+	}
+
+	return s.insert(event)
+}
+
+func (s *memoryEventStore) InsertBatch(ctx context.Context, events []*Event, atomic bool) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if atomic {
+		// Check every event up front, including against each other, so that
+		// nothing is inserted if any of them would fail.
+		errs := make([]error, len(events))
+		failed := false
+		ids := map[int64]bool{}
+		keys := map[string]bool{}
+		for i, event := range events {
+			if errs[i] = s.check(event); errs[i] == nil && ids[event.ID] {
+				errs[i] = errDuplicateEventID
+			} else if errs[i] == nil && len(event.IdempotencyKey) > 0 && keys[event.IdempotencyKey] {
+				errs[i] = errDuplicateKey
+			}
+			ids[event.ID] = true
+			keys[event.IdempotencyKey] = true
+			failed = failed || errs[i] != nil
+		}
+		if failed {
+			return errs, nil
+		}
+	}
+
+	errs := make([]error, len(events))
+	for i, event := range events {
+		if errs[i] = s.check(event); errs[i] == nil {
+			errs[i] = s.insert(event)
+		}
+	}
+
+	return errs, nil
+}
+
+// check returns the error that inserting the event would cause. s.mu must be
+// held.
+func (s *memoryEventStore) check(event *Event) error {
+	if _, ok := s.keys[event.IdempotencyKey]; ok && len(event.IdempotencyKey) > 0 {
+		return errDuplicateKey
+	} else if _, ok := s.events[event.ID]; ok {
+		return errDuplicateEventID
+	}
+	return nil
+}
+
+// insert adds the event. s.mu must be held.
+func (s *memoryEventStore) insert(event *Event) error {
+	c, err := copyEvent(event)
+	if err != nil {
+		return err
+	}
+
+	s.events[c.ID] = c
+	if len(event.IdempotencyKey) > 0 {
+		s.keys[event.IdempotencyKey] = c.ID
+	}
+
+	return nil
+}
+
+func (s *memoryEventStore) Get(ctx context.Context, id int64) (*Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	event, ok := s.events[id]
+	if !ok {
+		return nil, errEventNotFound
+	} else if _, ok := s.deleted[id]; ok {
+		return nil, errEventNotFound
+	}
+
+	c := *event
+	return &c, nil
+}
+
+func (s *memoryEventStore) GetByIdempotencyKey(ctx context.Context, key string) (*Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.keys[key]
+	if !ok {
+		return nil, errEventNotFound
+	}
+	event, ok := s.events[id]
+	if !ok {
+		return nil, errOrphanedKey
+	}
+
+	c := *event
+	return &c, nil
+}
+
+func (s *memoryEventStore) Query(ctx context.Context, q *EventsQuery) (*EventsPage, error) {
+	events := s.matching(q)
+	if len(events) > q.Limit+1 {
+		events = events[:q.Limit+1]
+	}
+
+	return newEventsPage(events, q.Limit), nil
+}
+
+func (s *memoryEventStore) Export(ctx context.Context, q *EventsQuery, fn func(*Event) error) error {
+	events := s.matching(q)
+	for i := range events {
+		if err := fn(&events[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matching returns every event matching q, in the requested order, starting
+// after q's cursor.
+func (s *memoryEventStore) matching(q *EventsQuery) []Event {
+	isEventType := map[string]bool{}
+	for _, eventType := range q.EventTypes {
+		isEventType[eventType] = true
+	}
+
+	// precedes reports whether (t1, id1) sorts before (t2, id2) in the requested
+	// order.
+	precedes := func(t1 time.Time, id1 int64, t2 time.Time, id2 int64) bool {
+		if t1.Equal(t2) {
+			if q.Order == orderAscending {
+				return id1 < id2
+			}
+			return id1 > id2
+		}
+		if q.Order == orderAscending {
+			return t1.Before(t2)
+		}
+		return t1.After(t2)
+	}
+
+	s.mu.RLock()
+	events := []Event{}
+	for id, event := range s.events {
+		if _, ok := s.deleted[id]; ok {
+			continue
+		} else if len(isEventType) > 0 && !isEventType[event.EventType] {
+			continue
+		} else if !q.Start.IsZero() && event.StartTime.Before(q.Start) {
+			continue
+		} else if !q.End.IsZero() && !event.StartTime.Before(q.End) {
+			continue
+		} else if len(q.Notes) > 0 && !strings.Contains(event.Notes, q.Notes) {
+			continue
+		} else if q.cursor != nil && !precedes(q.cursor.StartTime, q.cursor.ID, event.StartTime, event.ID) {
+			continue
+		}
+		events = append(events, *event)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(events, func(i, j int) bool {
+		return precedes(events[i].StartTime, events[i].ID, events[j].StartTime, events[j].ID)
+	})
+
+	return events
+}
+
+func (s *memoryEventStore) Update(ctx context.Context, id int64, patch *EventPatch) (*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, ok := s.events[id]
+	if !ok {
+		return nil, errEventNotFound
+	} else if _, ok := s.deleted[id]; ok {
+		return nil, errEventNotFound
+	}
+
+	updated := *event
+	if err := patch.Apply(&updated); err != nil {
+		return nil, invalidEventError{err}
+	}
+
+	c, err := copyEvent(&updated)
+	if err != nil {
+		return nil, err
+	}
+	s.events[id] = c
+
+	result := *c
+	return &result, nil
+}
+
+func (s *memoryEventStore) Delete(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.events[id]; !ok {
+		return errEventNotFound
+	} else if _, ok := s.deleted[id]; ok {
+		return errEventNotFound
+	}
+	s.deleted[id] = time.Now()
+
+	return nil
+}
+
+func (s *memoryEventStore) ListEventTypes(ctx context.Context) ([]EventType, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	eventTypes := make([]EventType, 0, len(s.types))
+	for _, eventType := range s.types {
+		eventTypes = append(eventTypes, eventType)
+	}
+	sort.Slice(eventTypes, func(i, j int) bool {
+		return eventTypes[i].Name < eventTypes[j].Name
+	})
+
+	return eventTypes, nil
+}
+
+func (s *memoryEventStore) CreateEventType(ctx context.Context, eventType *EventType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.types[eventType.Name]; ok {
+		return errDuplicateEventType
+	}
+	s.types[eventType.Name] = *eventType
+
+	return nil
+}
+
+func (s *memoryEventStore) UpdateEventType(ctx context.Context, eventType *EventType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.types[eventType.Name]; !ok {
+		return errEventTypeNotFound
+	}
+	s.types[eventType.Name] = *eventType
+
+	return nil
+}
+
+func (s *memoryEventStore) DeleteEventType(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.types[name]; !ok {
+		return errEventTypeNotFound
+	}
+	delete(s.types, name)
+
+	return nil
+}
+
+func (s *memoryEventStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]APIKey, 0, len(s.apiKeys))
+	for _, key := range s.apiKeys {
+		keys = append(keys, *key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreateTime.Equal(keys[j].CreateTime) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].CreateTime.Before(keys[j].CreateTime)
+	})
+
+	return keys, nil
+}
+
+func (s *memoryEventStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *key
+	c.Key = ""
+	s.apiKeys[c.ID] = &c
+
+	return nil
+}
+
+func (s *memoryEventStore) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.apiKeys {
+		if key.hash == hash && !key.RevokeTime.Valid {
+			c := *key
+			return &c, nil
+		}
+	}
+
+	return nil, errAPIKeyNotFound
+}
+
+func (s *memoryEventStore) RevokeAPIKey(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.apiKeys[id]
+	if !ok || key.RevokeTime.Valid {
+		return errAPIKeyNotFound
+	}
+	key.RevokeTime.Time = time.Now().UTC()
+	key.RevokeTime.Valid = true
+
+	return nil
+}
+
+func (s *memoryEventStore) Close() error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryEventStoreInsertAndGet(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryEventStore()
+
+	event := &Event{ID: 1, EventType: eventTypeExperiment, Notes: "x", StartTime: time.Now(), Metadata: map[string]string{"a": "b"}, IdempotencyKey: "key"}
+	if err := store.Insert(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if raw, ok := got.Metadata.(json.RawMessage); !ok || string(raw) != `{"a":"b"}` {
+		t.Errorf("got metadata %#v, want the raw JSON of the inserted metadata", got.Metadata)
+	}
+
+	if got, err := store.GetByIdempotencyKey(ctx, "key"); err != nil || got.ID != 1 {
+		t.Errorf("GetByIdempotencyKey returned %v, %v, want event 1", got, err)
+	}
+
+	if err := store.Insert(ctx, &Event{ID: 2, IdempotencyKey: "key"}); !errors.Is(err, errDuplicateKey) {
+		t.Errorf("inserting a duplicate key returned %v, want errDuplicateKey", err)
+	} else if err := store.Insert(ctx, &Event{ID: 1}); !errors.Is(err, errDuplicateEventID) {
+		t.Errorf("inserting a duplicate ID returned %v, want errDuplicateEventID", err)
+	}
+}
+
+func TestMemoryEventStoreAtomicBatch(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryEventStore()
+
+	errs, err := store.InsertBatch(ctx, []*Event{{ID: 1}, {ID: 1}}, true)
+	if err != nil {
+		t.Fatal(err)
+	} else if !errors.Is(errs[1], errDuplicateEventID) {
+		t.Errorf("got errors %v, want errDuplicateEventID for the second event", errs)
+	}
+	if _, err := store.Get(ctx, 1); !errors.Is(err, errEventNotFound) {
+		t.Errorf("a failed atomic batch inserted events")
+	}
+}
+
+func TestMemoryEventStoreUpdateAndDelete(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryEventStore()
+
+	start := time.Now()
+	if err := store.Insert(ctx, &Event{ID: 1, EventType: eventTypeExperiment, Notes: "x", StartTime: start}); err != nil {
+		t.Fatal(err)
+	}
+
+	notes := "y"
+	updated, err := store.Update(ctx, 1, &EventPatch{Notes: &notes, Metadata: map[string]interface{}{"a": "b"}})
+	if err != nil {
+		t.Fatal(err)
+	} else if updated.Notes != "y" || string(updated.Metadata.(json.RawMessage)) != `{"a":"b"}` {
+		t.Errorf("got %+v, want the patched event", updated)
+	}
+
+	endTime := &NullTime{}
+	endTime.Time = start.Add(-time.Hour)
+	endTime.Valid = true
+	if _, err := store.Update(ctx, 1, &EventPatch{EndTime: endTime}); !errors.As(err, &invalidEventError{}) {
+		t.Errorf("ending the event before it started returned %v, want an invalidEventError", err)
+	}
+
+	if err := store.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	} else if _, err := store.Get(ctx, 1); !errors.Is(err, errEventNotFound) {
+		t.Errorf("Get returned %v for a deleted event, want errEventNotFound", err)
+	} else if err := store.Delete(ctx, 1); !errors.Is(err, errEventNotFound) {
+		t.Errorf("deleting twice returned %v, want errEventNotFound", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// FieldError describes why a single metadata field was rejected. Field is a JSON
+// pointer into the event, e.g. "/metadata/machines".
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// MetadataError is returned when an event's metadata does not match the schema
+// registered for its type.
+type MetadataError struct {
+	EventType string
+	Fields    []FieldError
+}
+
+func (e *MetadataError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = fmt.Sprintf("%s: %s", field.Field, field.Error)
+	}
+	return fmt.Sprintf("metadata does not match the schema for event_type \"%s\": %s", e.EventType, strings.Join(messages, "; "))
+}
+
+// compileMetadataSchema compiles a JSON Schema for event metadata.
+func compileMetadataSchema(name string, raw json.RawMessage) (*jsonschema.Schema, error) {
+	schema, err := jsonschema.CompileString(fmt.Sprintf("mem://event-types/%s/metadata.json", url.PathEscape(name)), string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata_schema: %w", err)
+	}
+	return schema, nil
+}
+
+// validateMetadata checks metadata, which may be any value that marshals to JSON,
+// against the schema.
+func validateMetadata(eventType string, schema *jsonschema.Schema, metadata interface{}) error {
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata to []byte")
+	}
+
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return err
+	}
+
+	err = schema.Validate(v)
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+
+	// Only the leaves of the error tree say what is actually wrong.
+	metadataErr := &MetadataError{EventType: eventType}
+	var flatten func(*jsonschema.ValidationError)
+	flatten = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) == 0 {
+			metadataErr.Fields = append(metadataErr.Fields, FieldError{
+				Field: "/metadata" + ve.InstanceLocation,
+				Error: ve.Message,
+			})
+		}
+		for _, cause := range ve.Causes {
+			flatten(cause)
+		}
+	}
+	flatten(validationErr)
+
+	return metadataErr
+}
+
+// invalidEventData returns the per-field errors to include in the response for
+// an invalid event, if there are any.
+func invalidEventData(err error) interface{} {
+	var metadataErr *MetadataError
+	if errors.As(err, &metadataErr) {
+		return metadataErr.Fields
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "event_tracker"
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method, status code and source.",
+	}, []string{"route", "method", "code", "source"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latencies by route, method and source.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "source"})
+
+	eventsWrittenTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_written_total",
+		Help:      "Events written to the store by event type.",
+	}, []string{"event_type"})
+
+	lastEventTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_event_timestamp_seconds",
+		Help:      "Start time of the latest event by event type and metadata service.",
+	}, []string{"event_type", "service"})
+
+	slackPostFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "slack_post_failures_total",
+		Help:      "Events that could not be posted to Slack.",
+	})
+
+	dbErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "db_errors_total",
+		Help:      "Unexpected store errors by operation.",
+	}, []string{"operation"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		httpRequestsTotal,
+		httpRequestDuration,
+		eventsWrittenTotal,
+		lastEventTimestamp,
+		slackPostFailuresTotal,
+		dbErrorsTotal,
+	)
+}
+
+// requestSource groups routes by where requests come from.
+func requestSource(path string) string {
+	for _, source := range []string{"record", "github", "gitlab", "bitbucket", "slack", "grafana", "compat"} {
+		if strings.HasPrefix(path, "/api/v0/"+source) {
+			return source
+		}
+	}
+	return "api"
+}
+
+// statusRecorder remembers the status code and the number of bytes written
+// through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streaming handlers such as the export keep flushing.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// metricsMiddleware counts and times requests by route template, so that
+// requests for different event IDs share a series.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		source := requestSource(route)
+
+		httpRequestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status), source).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method, source).Observe(time.Since(start).Seconds())
+	})
+}
+
+// metricsServices are the metadata services that get their own lastEventTimestamp
+// series. Clients can name any service, so every other service shares the series
+// with an empty service rather than adding one each. main fills it from
+// --metrics-services.
+var metricsServices = map[string]bool{}
+
+// lastEventTimes backs lastEventTimestamp, which must never go backwards when
+// older events are backfilled.
+var lastEventTimes = struct {
+	sync.Mutex
+	times map[[2]string]time.Time
+}{times: map[[2]string]time.Time{}}
+
+// observeEvent updates lastEventTimestamp for an event.
+func observeEvent(event *Event) {
+	service, _ := metadataValue(event.Metadata, "service")
+	if !metricsServices[service] {
+		service = ""
+	}
+	key := [2]string{event.EventType, service}
+
+	lastEventTimes.Lock()
+	defer lastEventTimes.Unlock()
+	if event.StartTime.After(lastEventTimes.times[key]) {
+		lastEventTimes.times[key] = event.StartTime
+		lastEventTimestamp.WithLabelValues(event.EventType, service).Set(float64(event.StartTime.Unix()))
+	}
+}
+
+// observeEventWritten counts a newly written event.
+func observeEventWritten(event *Event) {
+	eventsWrittenTotal.WithLabelValues(event.EventType).Inc()
+	observeEvent(event)
+}
+
+// seedEventMetrics sets lastEventTimestamp from the most recent events of each
+// type, so that it does not start out empty after a restart.
+func (s *server) seedEventMetrics(ctx context.Context) error {
+	for _, name := range s.eventTypes.Names() {
+		page, err := s.store.Query(ctx, &EventsQuery{
+			EventTypes: []string{name},
+			Limit:      maxEventsLimit,
+			Order:      orderDescending,
+		})
+		if err != nil {
+			return err
+		}
+		for i := range page.Events {
+			observeEvent(&page.Events[i])
+		}
+	}
+
+	return nil
+}
+
+// instrumentedEventStore traces the operations of an EventStore and counts their
+// errors. Errors that are part of normal operation, such as a missing event, are
+// not counted.
+type instrumentedEventStore struct {
+	EventStore
+}
+
+// start starts a span for an operation. The returned function ends it and
+// counts err.
+func (s instrumentedEventStore) start(ctx context.Context, operation string) (context.Context, func(err error)) {
+	ctx, span := tracer.Start(ctx, "store."+operation)
+	return ctx, func(err error) {
+		s.observe(operation, err)
+		if s.expected(err) {
+			err = nil
+		}
+		endSpan(span, err)
+	}
+}
+
+// expected reports whether err is part of normal operation.
+func (s instrumentedEventStore) expected(err error) bool {
+	return err == nil ||
+		errors.Is(err, errEventNotFound) ||
+		errors.Is(err, errDuplicateEventID) ||
+		errors.Is(err, errDuplicateKey) ||
+		errors.Is(err, errEventTypeNotFound) ||
+		errors.Is(err, errDuplicateEventType) ||
+		errors.Is(err, errAPIKeyNotFound) ||
+		errors.As(err, &invalidEventError{}) ||
+		errors.Is(err, context.Canceled)
+}
+
+func (s instrumentedEventStore) observe(operation string, err error) {
+	if !s.expected(err) {
+		dbErrorsTotal.WithLabelValues(operation).Inc()
+	}
+}
+
+func (s instrumentedEventStore) Insert(ctx context.Context, event *Event) error {
+	ctx, end := s.start(ctx, "insert")
+	err := s.EventStore.Insert(ctx, event)
+	end(err)
+	return err
+}
+
+func (s instrumentedEventStore) InsertBatch(ctx context.Context, events []*Event, atomic bool) ([]error, error) {
+	ctx, end := s.start(ctx, "insert_batch")
+	errs, err := s.EventStore.InsertBatch(ctx, events, atomic)
+	end(err)
+	for _, err := range errs {
+		s.observe("insert_batch", err)
+	}
+	return errs, err
+}
+
+func (s instrumentedEventStore) Get(ctx context.Context, id int64) (*Event, error) {
+	ctx, end := s.start(ctx, "get")
+	event, err := s.EventStore.Get(ctx, id)
+	end(err)
+	return event, err
+}
+
+func (s instrumentedEventStore) GetByIdempotencyKey(ctx context.Context, key string) (*Event, error) {
+	ctx, end := s.start(ctx, "get_by_idempotency_key")
+	event, err := s.EventStore.GetByIdempotencyKey(ctx, key)
+	end(err)
+	return event, err
+}
+
+func (s instrumentedEventStore) Query(ctx context.Context, q *EventsQuery) (*EventsPage, error) {
+	ctx, end := s.start(ctx, "query")
+	page, err := s.EventStore.Query(ctx, q)
+	end(err)
+	return page, err
+}
+
+func (s instrumentedEventStore) Export(ctx context.Context, q *EventsQuery, fn func(*Event) error) error {
+	ctx, end := s.start(ctx, "export")
+
+	// Only count errors from the store, not from fn.
+	var fnErr error
+	err := s.EventStore.Export(ctx, q, func(event *Event) error {
+		fnErr = fn(event)
+		return fnErr
+	})
+	if fnErr != nil {
+		end(nil)
+	} else {
+		end(err)
+	}
+	return err
+}
+
+func (s instrumentedEventStore) Update(ctx context.Context, id int64, patch *EventPatch) (*Event, error) {
+	ctx, end := s.start(ctx, "update")
+	event, err := s.EventStore.Update(ctx, id, patch)
+	end(err)
+	return event, err
+}
+
+func (s instrumentedEventStore) Delete(ctx context.Context, id int64) error {
+	ctx, end := s.start(ctx, "delete")
+	err := s.EventStore.Delete(ctx, id)
+	end(err)
+	return err
+}
+
+func (s instrumentedEventStore) ListEventTypes(ctx context.Context) ([]EventType, error) {
+	ctx, end := s.start(ctx, "list_event_types")
+	eventTypes, err := s.EventStore.ListEventTypes(ctx)
+	end(err)
+	return eventTypes, err
+}
+
+func (s instrumentedEventStore) CreateEventType(ctx context.Context, eventType *EventType) error {
+	ctx, end := s.start(ctx, "create_event_type")
+	err := s.EventStore.CreateEventType(ctx, eventType)
+	end(err)
+	return err
+}
+
+func (s instrumentedEventStore) UpdateEventType(ctx context.Context, eventType *EventType) error {
+	ctx, end := s.start(ctx, "update_event_type")
+	err := s.EventStore.UpdateEventType(ctx, eventType)
+	end(err)
+	return err
+}
+
+func (s instrumentedEventStore) DeleteEventType(ctx context.Context, name string) error {
+	ctx, end := s.start(ctx, "delete_event_type")
+	err := s.EventStore.DeleteEventType(ctx, name)
+	end(err)
+	return err
+}
+
+func (s instrumentedEventStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	ctx, end := s.start(ctx, "list_api_keys")
+	keys, err := s.EventStore.ListAPIKeys(ctx)
+	end(err)
+	return keys, err
+}
+
+func (s instrumentedEventStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	ctx, end := s.start(ctx, "create_api_key")
+	err := s.EventStore.CreateAPIKey(ctx, key)
+	end(err)
+	return err
+}
+
+func (s instrumentedEventStore) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	ctx, end := s.start(ctx, "get_api_key_by_hash")
+	key, err := s.EventStore.GetAPIKeyByHash(ctx, hash)
+	end(err)
+	return key, err
+}
+
+func (s instrumentedEventStore) RevokeAPIKey(ctx context.Context, id string) error {
+	ctx, end := s.start(ctx, "revoke_api_key")
+	err := s.EventStore.RevokeAPIKey(ctx, id)
+	end(err)
+	return err
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveEventBoundsServices(t *testing.T) {
+	metricsServices["api"] = true
+	t.Cleanup(func() { delete(metricsServices, "api") })
+
+	// A type of its own keeps the series apart from other tests'.
+	const eventType = "METRICS TEST"
+	start := time.Unix(1600000000, 0)
+	for i, service := range []string{"api", "made-up-1", "made-up-2"} {
+		observeEvent(&Event{
+			EventType: eventType,
+			StartTime: start.Add(time.Duration(i) * time.Second),
+			Metadata:  map[string]interface{}{"service": service},
+		})
+	}
+
+	if got := testutil.ToFloat64(lastEventTimestamp.WithLabelValues(eventType, "api")); got != float64(start.Unix()) {
+		t.Errorf("got %v for the api service, want %d", got, start.Unix())
+	}
+	if got := testutil.ToFloat64(lastEventTimestamp.WithLabelValues(eventType, "")); got != float64(start.Unix()+2) {
+		t.Errorf("got %v for other services, want %d", got, start.Unix()+2)
+	}
+	for _, service := range []string{"made-up-1", "made-up-2"} {
+		if lastEventTimestamp.DeleteLabelValues(eventType, service) {
+			t.Errorf("service %q has its own series", service)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+//go:embed migrations
+var migrationsFS embed.FS
+
+const (
+	migrateUp     = "up"
+	migrateDown   = "down"
+	migrateStatus = "status"
+)
+
+// migration is a pair of files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql under migrations/<dialect>.
+type migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type migrator struct {
+	db         *sql.DB
+	migrations []migration
+}
+
+func newMigrator(db *sql.DB, dialect string) (*migrator, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationsFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for store \"%s\"", dialect)
+	}
+
+	byVersion := map[int64]*migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = migrateUp
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = migrateDown
+		default:
+			return nil, fmt.Errorf("unexpected migration file \"%s\"", fileName)
+		}
+
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		split := strings.SplitN(base, "_", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("migration file \"%s\" must be named <version>_<name>.%s.sql", fileName, direction)
+		}
+		version, err := strconv.ParseInt(split[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file \"%s\" has an invalid version", fileName)
+		}
+
+		contents, err := fs.ReadFile(migrationsFS, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: split[1]}
+			byVersion[version] = m
+		} else if m.Name != split[1] {
+			return nil, fmt.Errorf("migration version %d is used by both \"%s\" and \"%s\"", version, m.Name, split[1])
+		}
+
+		if direction == migrateUp {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	mg := &migrator{db: db}
+	for _, m := range byVersion {
+		if len(m.Up) == 0 || len(m.Down) == 0 {
+			return nil, fmt.Errorf("migration %d_%s must have both an up and a down file", m.Version, m.Name)
+		}
+		mg.migrations = append(mg.migrations, *m)
+	}
+	sort.Slice(mg.migrations, func(i, j int) bool {
+		return mg.migrations[i].Version < mg.migrations[j].Version
+	})
+
+	return mg, nil
+}
+
+func (mg *migrator) ensureTable(ctx context.Context) error {
+	_, err := mg.db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version BIGINT NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	applied_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (version)
+)
+`)
+	return err
+}
+
+// applied returns the time at which each applied migration was run.
+func (mg *migrator) applied(ctx context.Context) (map[int64]time.Time, error) {
+	if err := mg.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := mg.db.QueryContext(ctx, `SELECT version, applied_time FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedTime time.Time
+		if err := rows.Scan(&version, &appliedTime); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedTime
+	}
+
+	return applied, rows.Err()
+}
+
+// exec runs each statement of a migration file in order. Statements are
+// separated by a semicolon at the end of a line.
+func (mg *migrator) exec(ctx context.Context, tx *sql.Tx, contents string) error {
+	for _, statement := range strings.Split(contents, ";\n") {
+		if len(strings.TrimSpace(statement)) == 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Up applies every pending migration in order.
+func (mg *migrator) Up(ctx context.Context) error {
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range mg.migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		// MySQL commits DDL statements implicitly, so the transaction only really
+		// protects the schema_migrations row there.
+		tx, err := mg.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := mg.exec(ctx, tx, m.Up); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		slog.Info("applied migration", "version", m.Version, "name", m.Name)
+	}
+
+	return nil
+}
+
+// Down reverts the most recently applied migration.
+func (mg *migrator) Down(ctx context.Context) error {
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(mg.migrations) - 1; i >= 0; i-- {
+		m := mg.migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		tx, err := mg.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := mg.exec(ctx, tx, m.Down); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, m.Version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		slog.Info("reverted migration", "version", m.Version, "name", m.Name)
+		return nil
+	}
+
+	slog.Info("no migrations to revert")
+	return nil
+}
+
+// Pending returns the migrations that have not been applied yet.
+func (mg *migrator) Pending(ctx context.Context) ([]migration, error) {
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []migration{}
+	for _, m := range mg.migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, nil
+}
+
+// Status prints every known migration and when it was applied.
+func (mg *migrator) Status(ctx context.Context) error {
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, m := range mg.migrations {
+		status := "pending"
+		if appliedTime, ok := applied[m.Version]; ok {
+			status = appliedTime.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, status)
+	}
+
+	return w.Flush()
+}
+
+// Migrate implements the "migrate up|down|status" subcommand.
+func (s *server) Migrate(action string) {
+	db, err := s.openDB()
+	if err != nil {
+		fatal("failed to open database", "store", *s.Store, "error", err.Error())
+	}
+	defer db.Close()
+
+	mg, err := newMigrator(db, *s.Store)
+	if err != nil {
+		fatal("failed to load migrations", "error", err.Error())
+	}
+
+	ctx := context.Background()
+	switch action {
+	case migrateUp:
+		err = mg.Up(ctx)
+	case migrateDown:
+		err = mg.Down(ctx)
+	case migrateStatus:
+		err = mg.Status(ctx)
+	default:
+		err = fmt.Errorf("usage: migrate %s|%s|%s", migrateUp, migrateDown, migrateStatus)
+	}
+
+	if err != nil {
+		fatal("migrate failed", "action", action, "error", err.Error())
+	}
+}
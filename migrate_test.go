@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestSQLiteMigrator returns a migrator for an empty SQLite database in a
+// temporary directory.
+func newTestSQLiteMigrator(t *testing.T) *migrator {
+	t.Helper()
+
+	db, err := openSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	mg, err := newMigrator(db, storeSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mg
+}
+
+func TestMigrationsAreComplete(t *testing.T) {
+	for _, dialect := range []string{storeMySQL, storeSQLite} {
+		mg, err := newMigrator(nil, dialect)
+		if err != nil {
+			t.Fatalf("%s: %v", dialect, err)
+		}
+		for i, m := range mg.migrations {
+			if m.Version != int64(i+1) {
+				t.Errorf("%s: migration %d_%s is out of sequence", dialect, m.Version, m.Name)
+			}
+		}
+	}
+}
+
+func TestMySQLMigrationsAvoidMariaDBSyntax(t *testing.T) {
+	mg, err := newMigrator(nil, storeMySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mg.migrations {
+		for _, contents := range []string{m.Up, m.Down} {
+			if upper := strings.ToUpper(contents); strings.Contains(upper, "ADD COLUMN IF NOT EXISTS") || strings.Contains(upper, "DROP COLUMN IF EXISTS") {
+				t.Errorf("migration %d_%s uses MariaDB only syntax", m.Version, m.Name)
+			}
+		}
+	}
+}
+
+func TestMigratorUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	mg := newTestSQLiteMigrator(t)
+
+	if pending, err := mg.Pending(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(pending) != len(mg.migrations) {
+		t.Fatalf("got %d pending migrations on an empty database, want %d", len(pending), len(mg.migrations))
+	}
+
+	if err := mg.Up(ctx); err != nil {
+		t.Fatal(err)
+	} else if pending, err := mg.Pending(ctx); err != nil || len(pending) != 0 {
+		t.Fatalf("got %d pending migrations after up, %v", len(pending), err)
+	}
+	// Applied migrations are not run again.
+	if err := mg.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The store works against the migrated schema.
+	store := NewSQLiteEventStore(mg.db)
+	if types, err := store.ListEventTypes(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(types) != len(defaultEventTypes) {
+		t.Errorf("got %d event types, want the %d defaults", len(types), len(defaultEventTypes))
+	}
+
+	latest := mg.migrations[len(mg.migrations)-1]
+	if err := mg.Down(ctx); err != nil {
+		t.Fatal(err)
+	} else if pending, err := mg.Pending(ctx); err != nil || len(pending) != 1 || pending[0].Version != latest.Version {
+		t.Fatalf("got pending migrations %v after down, want only %d_%s", pending, latest.Version, latest.Name)
+	}
+
+	// Every migration can be reverted and applied again.
+	for range mg.migrations {
+		if err := mg.Down(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mg.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
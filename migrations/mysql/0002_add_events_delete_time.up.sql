@@ -0,0 +1 @@
+ALTER TABLE events ADD COLUMN delete_time TIMESTAMP NULL DEFAULT NULL;
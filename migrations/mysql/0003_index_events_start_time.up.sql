@@ -0,0 +1 @@
+CREATE INDEX events_start_time ON events (start_time, id);
@@ -0,0 +1,6 @@
+CREATE TABLE idempotency_keys (
+	idempotency_key VARCHAR(255) NOT NULL,
+	event_id BIGINT(20) UNSIGNED NOT NULL,
+	insert_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (idempotency_key)
+);
@@ -0,0 +1,17 @@
+CREATE TABLE event_types (
+	name VARCHAR(20) NOT NULL,
+	display_name VARCHAR(255) NOT NULL,
+	color VARCHAR(7) NOT NULL DEFAULT '#808080',
+	slack_channel VARCHAR(255) NOT NULL DEFAULT '',
+	PRIMARY KEY (name)
+);
+INSERT INTO event_types (name, display_name, color) VALUES
+	('DEPLOYMENT', 'Deployment', '#1f77b4'),
+	('MERGE', 'Merge', '#2ca02c'),
+	('PUSH', 'Push', '#98df8a'),
+	('APP RELEASE', 'App Release', '#9467bd'),
+	('EXPERIMENT', 'Experiment', '#ff7f0e'),
+	('OPS ACTIVITY', 'Ops Activity', '#8c564b'),
+	('INCIDENT', 'Incident', '#d62728');
+-- Merged pull requests used to be recorded under their own unregistered type.
+UPDATE events SET event_type = 'MERGE' WHERE event_type = 'PULL REQUEST';
@@ -0,0 +1 @@
+ALTER TABLE event_types DROP COLUMN metadata_schema;
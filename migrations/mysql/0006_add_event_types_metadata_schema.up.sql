@@ -0,0 +1,25 @@
+ALTER TABLE event_types ADD COLUMN metadata_schema JSON NULL DEFAULT NULL;
+UPDATE event_types SET metadata_schema = '{
+	"type": "object",
+	"properties": {
+		"type": {
+			"enum": ["OKCONTENT", "WEBSRV", "RPCSRV", "DBPROX", "OKAPI", "GRPC", "CONF"]
+		},
+		"service": {
+			"type": "string"
+		},
+		"machines": {
+			"type": "array",
+			"items": {"type": "string"},
+			"minItems": 1
+		}
+	},
+	"required": ["type", "machines"],
+	"if": {
+		"properties": {"type": {"enum": ["RPCSRV", "DBPROX", "GRPC"]}}
+	},
+	"then": {
+		"properties": {"service": {"minLength": 1}},
+		"required": ["service"]
+	}
+}' WHERE name = 'DEPLOYMENT';
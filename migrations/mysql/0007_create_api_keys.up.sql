@@ -0,0 +1,12 @@
+CREATE TABLE api_keys (
+	id VARCHAR(16) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	key_hash CHAR(64) NOT NULL,
+	scopes JSON NOT NULL,
+	event_types JSON NOT NULL,
+	create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoke_time TIMESTAMP NULL DEFAULT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY (key_hash)
+);
+ALTER TABLE events ADD COLUMN api_key_id VARCHAR(16) NULL DEFAULT NULL;
@@ -0,0 +1 @@
+ALTER TABLE events ADD COLUMN from_integration BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS events (
+	id INTEGER NOT NULL PRIMARY KEY,
+	event_type VARCHAR(20) NOT NULL,
+	start_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	end_time TIMESTAMP NULL DEFAULT NULL,
+	notes TEXT DEFAULT NULL,
+	metadata TEXT DEFAULT NULL,
+	insert_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	delete_time TIMESTAMP NULL DEFAULT NULL
+);
@@ -0,0 +1 @@
+DROP INDEX events_start_time;
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// https://mariadb.com/kb/en/mariadb-error-codes/
+const mysqlErrDuplicateEntry = 1062
+
+// openMySQL connects to MySQL (or MariaDB). The DSN must set parseTime=true.
+func openMySQL(dsn string) (*sql.DB, error) {
+	return sql.Open("mysql", dsn)
+}
+
+// NewMySQLEventStore expects the schema to be up to date with
+// migrations/mysql.
+func NewMySQLEventStore(db *sql.DB) EventStore {
+	return &sqlEventStore{
+		db:         db,
+		lockClause: "FOR UPDATE",
+		isDuplicateKey: func(err error) bool {
+			var mysqlErr *mysql.MySQLError
+			return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry
+		},
+	}
+}
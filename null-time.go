@@ -27,7 +27,7 @@ func (n *NullTime) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func (n *NullTime) MarshalJSON() ([]byte, error) {
+func (n NullTime) MarshalJSON() ([]byte, error) {
 	if n.Valid {
 		return json.Marshal(n.Time)
 	}
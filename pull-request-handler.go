@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 )
@@ -18,6 +19,9 @@ type PullRequestData struct {
 		User      struct {
 			Login string `json:"login"`
 		} `json:"user"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
 	} `json:"pull_request"`
 	Repository struct {
 		FullName string `json:"full_name"`
@@ -41,20 +45,28 @@ func (s *server) PullRequestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	event := &Event{
-		EventType: "PULL REQUEST",
-		StartTime: request.PullRequest.UpdatedAt,
-		Notes:     request.PullRequest.Title,
-		Metadata:  request,
+		EventType:       eventTypeMerge,
+		StartTime:       request.PullRequest.UpdatedAt,
+		Notes:           request.PullRequest.Title,
+		Metadata:        request,
+		fromIntegration: true,
+	}
+
+	// Merges are routed by the branch that they were merged into.
+	if !s.route(event, request.Repository.FullName, "refs/heads/"+request.PullRequest.Base.Ref) {
+		respondWithJSON(w, http.StatusOK, nil, "", request)
+		return
+	} else if _, ok := s.eventTypes.Lookup(event.EventType); !ok {
+		respondWithJSON(w, http.StatusOK, nil, fmt.Sprintf("event type \"%s\" is not registered", event.EventType), request)
+		return
+	}
+
+	if delivery := r.Header.Get(githubDeliverHeader); len(delivery) > 0 {
+		event.IdempotencyKey = "github:" + delivery
 	}
 
 	if err := s.writeToDBAndLog(r.Context(), event); err != nil {
-		respondWithJSON(
-			w,
-			http.StatusInternalServerError,
-			err,
-			"failed to write to database",
-			nil,
-		)
+		respondWithWriteError(w, err)
 		return
 	}
 
@@ -2,13 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
-	"strings"
 	"time"
 )
 
 type PushData struct {
-	Ref        string `json:"ref"`
+	Ref string `json:"ref"`
+	// Deleted is set for pushes that delete the ref, which have no head commit.
+	Deleted    bool `json:"deleted"`
 	HeadCommit struct {
 		Message   string    `json:"message"`
 		Timestamp time.Time `json:"timestamp"`
@@ -49,27 +51,41 @@ func (s *server) PushHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !strings.Contains(request.Ref, request.Repository.DefaultBranch) &&
-		!strings.Contains(request.Ref, request.Repository.MasterBranch) {
+	if request.Deleted {
+		respondWithJSON(w, http.StatusOK, nil, "deleted refs are not recorded", request)
+		return
+	}
+
+	// Without routing rules, only pushes to the default branch are recorded.
+	if s.routingRules == nil &&
+		request.Ref != "refs/heads/"+request.Repository.DefaultBranch &&
+		request.Ref != "refs/heads/"+request.Repository.MasterBranch {
 		respondWithJSON(w, http.StatusOK, nil, "", request)
 		return
 	}
 
 	event := &Event{
-		EventType: "PUSH",
-		StartTime: request.HeadCommit.Timestamp,
-		Notes:     request.HeadCommit.Message,
-		Metadata:  request,
+		EventType:       eventTypePush,
+		StartTime:       request.HeadCommit.Timestamp,
+		Notes:           request.HeadCommit.Message,
+		Metadata:        request,
+		fromIntegration: true,
+	}
+
+	if !s.route(event, request.Repository.FullName, request.Ref) {
+		respondWithJSON(w, http.StatusOK, nil, "", request)
+		return
+	} else if _, ok := s.eventTypes.Lookup(event.EventType); !ok {
+		respondWithJSON(w, http.StatusOK, nil, fmt.Sprintf("event type \"%s\" is not registered", event.EventType), request)
+		return
+	}
+
+	if delivery := r.Header.Get(githubDeliverHeader); len(delivery) > 0 {
+		event.IdempotencyKey = "github:" + delivery
 	}
 
 	if err := s.writeToDBAndLog(r.Context(), event); err != nil {
-		respondWithJSON(
-			w,
-			http.StatusInternalServerError,
-			err,
-			"failed to write to database",
-			nil,
-		)
+		respondWithWriteError(w, err)
 		return
 	}
 
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+const (
+	applicationNDJSON = "application/x-ndjson"
+
+	maxBatchSize     = 1000
+	maxNDJSONLineLen = 1 << 20
+)
+
+// BatchItemResult reports what happened to a single item of a batch.
+type BatchItemResult struct {
+	Index  int         `json:"index"`
+	Status int         `json:"code"`
+	Error  string      `json:"error"`
+	Data   interface{} `json:"data"`
+	Event  *Event      `json:"event,omitempty"`
+}
+
+// decodeBatch splits the request body into one raw JSON document per item. The
+// body is either a JSON array or newline delimited JSON.
+func decodeBatch(r *http.Request) ([]json.RawMessage, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get(contentTypeHeader))
+	if err != nil {
+		return nil, err
+	}
+
+	items := []json.RawMessage{}
+	switch mediaType {
+	case applicationJSON:
+		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+			return nil, err
+		}
+	case applicationNDJSON:
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLineLen)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			items = append(items, json.RawMessage(append([]byte{}, line...)))
+		}
+		if err := scanner.Err(); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content type \"%s\"", mediaType)
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("batch is empty")
+	} else if len(items) > maxBatchSize {
+		return nil, fmt.Errorf("batch has %d items; the limit is %d", len(items), maxBatchSize)
+	}
+
+	return items, nil
+}
+
+// RecordBatchHandler records many events at once. Valid events are inserted in a
+// single transaction; with ?atomic=true nothing is inserted unless every event is
+// valid and can be inserted.
+func (s *server) RecordBatchHandler(w http.ResponseWriter, r *http.Request) {
+	atomic := false
+	if value := r.URL.Query().Get("atomic"); len(value) > 0 {
+		var err error
+		if atomic, err = strconv.ParseBool(value); err != nil {
+			respondWithJSON(w, http.StatusBadRequest, fmt.Errorf("atomic must be \"true\" or \"false\""), "", nil)
+			return
+		}
+	}
+
+	items, err := decodeBatch(r)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	results := make([]BatchItemResult, len(items))
+	events := []*Event{}
+	indexes := []int{}
+	// cause is the first item's failure, which decides the status of a rolled
+	// back batch.
+	var cause error
+	fail := func(i int, err error) {
+		results[i].Status = writeErrorStatus(err)
+		results[i].Error = err.Error()
+		if cause == nil {
+			cause = err
+		}
+	}
+	for i, item := range items {
+		results[i] = BatchItemResult{Index: i, Status: http.StatusOK}
+
+		event := &Event{}
+		if err := json.Unmarshal(item, event); err != nil {
+			fail(i, invalidEventError{err})
+			continue
+		}
+
+		if err := event.ValidateAndRectify(s.eventTypes); err != nil {
+			fail(i, invalidEventError{err})
+			results[i].Data = invalidEventData(err)
+			continue
+		} else if err := authorizeEvent(r.Context(), event); err != nil {
+			fail(i, err)
+			continue
+		}
+
+		addTraceID(r.Context(), event)
+		events = append(events, event)
+		indexes = append(indexes, i)
+	}
+
+	failed := len(events) < len(items)
+	if !(atomic && failed) {
+		errs, err := s.store.InsertBatch(r.Context(), events, atomic)
+		if err != nil {
+			respondWithWriteError(w, err)
+			return
+		}
+
+		for j, err := range errs {
+			if err != nil {
+				fail(indexes[j], err)
+				failed = true
+			}
+		}
+	}
+
+	// Nothing was written if an atomic batch failed, so report every item that
+	// was fine on its own as rolled back.
+	if atomic && failed {
+		for i := range results {
+			if results[i].Status == http.StatusOK {
+				results[i].Status = http.StatusConflict
+				results[i].Error = "rolled back because another item in the batch failed"
+			}
+		}
+		respondWithJSON(w, writeErrorStatus(cause), fmt.Errorf("batch rolled back: %w", cause), "", results)
+		return
+	}
+
+	written := []*Event{}
+	for j, event := range events {
+		i := indexes[j]
+		if results[i].Status != http.StatusOK {
+			continue
+		}
+		results[i].Event = event
+		observeEventWritten(event)
+		written = append(written, event)
+	}
+
+	status := http.StatusOK
+	if failed {
+		status = http.StatusMultiStatus
+	}
+	respondWithJSON(w, status, nil, "", results)
+
+	// The events are committed, so post them to Slack without holding up the
+	// response. logToSlackChannel logs and counts its own failures.
+	ctx := context.WithoutCancel(r.Context())
+	s.slackPosts.Add(1)
+	go func() {
+		defer s.slackPosts.Done()
+		for _, event := range written {
+			s.logToSlackChannel(ctx, event)
+		}
+	}()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRecordBatchHandlerPostsToSlackAfterResponding(t *testing.T) {
+	s := newTestServer(t)
+	slack := useFakeSlack(t, s, "#events")
+
+	w := s.serve(t, http.MethodPost, "/api/v0/record/batch", []map[string]interface{}{
+		{"event_type": eventTypeExperiment, "notes": "first"},
+		{"event_type": eventTypeExperiment},
+		{"event_type": eventTypeExperiment, "notes": "second"},
+	}, nil)
+	expectStatus(t, w, http.StatusMultiStatus)
+
+	s.slackPosts.Wait()
+	if posted := slack.posted(); len(posted) != 2 {
+		t.Errorf("posted %q, want the two recorded events", posted)
+	}
+}
+
+func TestRecordBatchHandlerRollbackStatus(t *testing.T) {
+	s := newTestServer(t)
+	*s.RequireAPIKey = true
+	auth := createTestAPIKey(t, s, scopeEventsWrite, eventTypeExperiment)
+
+	for name, test := range map[string]struct {
+		item   map[string]interface{}
+		status int
+	}{
+		"invalid":   {map[string]interface{}{"event_type": eventTypeExperiment}, http.StatusBadRequest},
+		"forbidden": {map[string]interface{}{"event_type": eventTypeOpsActivity, "notes": "x"}, http.StatusForbidden},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expectStatus(t, s.serve(t, http.MethodPost, "/api/v0/record/batch?atomic=true", []map[string]interface{}{
+				{"event_type": eventTypeExperiment, "notes": "fine"},
+				test.item,
+			}, auth), test.status)
+		})
+	}
+
+	if events := storedEvents(t, s); len(events) != 0 {
+		t.Errorf("got %d events, want the batches rolled back", len(events))
+	}
+}
@@ -1,28 +1,76 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"net/http"
 )
 
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// maxIdempotencyKeyLength is the longest Idempotency-Key accepted.
+	maxIdempotencyKeyLength = 255
+)
+
+var errIdempotencyKeyTooLong = fmt.Errorf("%s must be at most %d bytes", idempotencyKeyHeader, maxIdempotencyKeyLength)
+
+// RecordHandler records a single event, given either as an Event or as a
+// CloudEvent in structured or binary mode.
 func (s *server) RecordHandler(w http.ResponseWriter, r *http.Request) {
 	event := Event{}
-	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+	if isCloudEvent(r) {
+		cloudEvent, err := readCloudEvent(r)
+		if err != nil {
+			respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+			return
+		}
+		event = *cloudEvent.Event()
+	} else if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if err := setClientIdempotencyKey(r, &event); err != nil {
 		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
 		return
 	}
 
-	err := s.writeToDBAndLog(r.Context(), &event)
-	if err != nil {
-		respondWithJSON(
-			w,
-			http.StatusInternalServerError,
-			err,
-			"failed to write to database",
-			nil,
-		)
+	if err := s.writeToDBAndLog(r.Context(), &event); err != nil {
+		respondWithWriteError(w, err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, err, "", event)
+	respondWithJSON(w, http.StatusOK, nil, "", event)
+}
+
+// setClientIdempotencyKey sets the event's idempotency key from the
+// Idempotency-Key header, if any, and scopes the key, however it was set, to
+// the client.
+func setClientIdempotencyKey(r *http.Request, event *Event) error {
+	if key := r.Header.Get(idempotencyKeyHeader); len(key) > maxIdempotencyKeyLength {
+		return errIdempotencyKeyTooLong
+	} else if len(key) > 0 {
+		event.IdempotencyKey = "record:" + key
+	}
+
+	if len(event.IdempotencyKey) > 0 {
+		event.IdempotencyKey = clientIdempotencyKey(r.Context(), event.IdempotencyKey)
+	}
+	return nil
+}
+
+// clientIdempotencyKey scopes an idempotency key chosen by a client to the API
+// key of the request, so that a client that sends another client's key neither
+// gets that client's event back nor has its own event dropped. The key is
+// hashed so that it fits the idempotency_keys column.
+func clientIdempotencyKey(ctx context.Context, key string) string {
+	apiKeyID := ""
+	if apiKey, ok := apiKeyFromContext(ctx); ok {
+		apiKeyID = apiKey.ID
+	}
+	hash := sha256.Sum256([]byte(key))
+	return "client:" + apiKeyID + ":" + hex.EncodeToString(hash[:])
 }
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ReleaseMetadata is the metadata of APP RELEASE events recorded from GitHub. It
+// is the same for releases, tags and packages so that they can be queried and
+// posted to Slack alike.
+type ReleaseMetadata struct {
+	// GitHubEvent is the webhook that recorded the event, e.g. "release".
+	GitHubEvent  string         `json:"github_event"`
+	Action       string         `json:"action"`
+	Repository   string         `json:"repository"`
+	Tag          string         `json:"tag"`
+	Name         string         `json:"name,omitempty"`
+	Package      string         `json:"package,omitempty"`
+	PackageType  string         `json:"package_type,omitempty"`
+	ReleaseNotes string         `json:"release_notes,omitempty"`
+	Author       string         `json:"author"`
+	URL          string         `json:"url,omitempty"`
+	Prerelease   bool           `json:"prerelease,omitempty"`
+	Assets       []ReleaseAsset `json:"assets"`
+}
+
+type ReleaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+}
+
+// GitHubRepositoryData is the part of every webhook payload that identifies the
+// repository and the user who triggered it.
+type GitHubRepositoryData struct {
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+}
+
+type ReleaseData struct {
+	GitHubRepositoryData
+	Action  string `json:"action"`
+	Release struct {
+		URL         string    `json:"html_url"`
+		TagName     string    `json:"tag_name"`
+		Name        string    `json:"name"`
+		Body        string    `json:"body"`
+		Prerelease  bool      `json:"prerelease"`
+		PublishedAt time.Time `json:"published_at"`
+		Author      struct {
+			Login string `json:"login"`
+		} `json:"author"`
+		Assets []struct {
+			Name string `json:"name"`
+			URL  string `json:"browser_download_url"`
+			Size int64  `json:"size"`
+		} `json:"assets"`
+	} `json:"release"`
+}
+
+// RefData is the payload of both create and delete webhooks.
+type RefData struct {
+	GitHubRepositoryData
+	Ref     string `json:"ref"`
+	RefType string `json:"ref_type"`
+}
+
+type RegistryPackageData struct {
+	GitHubRepositoryData
+	Action          string `json:"action"`
+	RegistryPackage struct {
+		Name           string `json:"name"`
+		PackageType    string `json:"package_type"`
+		URL            string `json:"html_url"`
+		PackageVersion struct {
+			Version   string    `json:"version"`
+			Name      string    `json:"name"`
+			Body      string    `json:"body"`
+			URL       string    `json:"html_url"`
+			CreatedAt time.Time `json:"created_at"`
+			Author    struct {
+				Login string `json:"login"`
+			} `json:"author"`
+			PackageFiles []struct {
+				Name string `json:"name"`
+				URL  string `json:"download_url"`
+				Size int64  `json:"size"`
+			} `json:"package_files"`
+		} `json:"package_version"`
+	} `json:"registry_package"`
+}
+
+// ReleaseHandler records published GitHub releases.
+func (s *server) ReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	request := ReleaseData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if request.Action != "published" {
+		respondWithJSON(w, http.StatusOK, nil, "", request)
+		return
+	}
+
+	release := request.Release
+	metadata := &ReleaseMetadata{
+		GitHubEvent:  releaseEvent,
+		Action:       request.Action,
+		Repository:   request.Repository.FullName,
+		Tag:          release.TagName,
+		Name:         release.Name,
+		ReleaseNotes: release.Body,
+		Author:       release.Author.Login,
+		URL:          release.URL,
+		Prerelease:   release.Prerelease,
+		Assets:       []ReleaseAsset{},
+	}
+	for _, asset := range release.Assets {
+		metadata.Assets = append(metadata.Assets, ReleaseAsset{Name: asset.Name, URL: asset.URL, Size: asset.Size})
+	}
+
+	notes := release.Name
+	if len(notes) == 0 {
+		notes = release.TagName
+	}
+
+	s.recordRelease(w, r, request.Repository.FullName, "refs/tags/"+release.TagName, &Event{
+		EventType: eventTypeAppRelease,
+		StartTime: release.PublishedAt,
+		Notes:     fmt.Sprintf("%s released %s", request.Repository.FullName, notes),
+		Metadata:  metadata,
+	})
+}
+
+// TagHandler records tags being created or deleted. Branches are ignored.
+func (s *server) TagHandler(w http.ResponseWriter, r *http.Request) {
+	request := RefData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if request.RefType != "tag" {
+		respondWithJSON(w, http.StatusOK, nil, "", request)
+		return
+	}
+
+	githubEvent := r.Header.Get(githubEventHeader)
+	action, preposition := "created", "in"
+	if githubEvent == deleteEvent {
+		action, preposition = "deleted", "from"
+	}
+
+	s.recordRelease(w, r, request.Repository.FullName, "refs/tags/"+request.Ref, &Event{
+		EventType: eventTypeAppRelease,
+		Notes:     fmt.Sprintf("Tag %s %s %s %s", request.Ref, action, preposition, request.Repository.FullName),
+		Metadata: &ReleaseMetadata{
+			GitHubEvent: githubEvent,
+			Action:      action,
+			Repository:  request.Repository.FullName,
+			Tag:         request.Ref,
+			Author:      request.Sender.Login,
+			Assets:      []ReleaseAsset{},
+		},
+	})
+}
+
+// RegistryPackageHandler records packages published to GitHub Packages.
+func (s *server) RegistryPackageHandler(w http.ResponseWriter, r *http.Request) {
+	request := RegistryPackageData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	if request.Action != "published" {
+		respondWithJSON(w, http.StatusOK, nil, "", request)
+		return
+	}
+
+	pkg := request.RegistryPackage
+	version := pkg.PackageVersion
+	metadata := &ReleaseMetadata{
+		GitHubEvent:  registryPackageEvent,
+		Action:       request.Action,
+		Repository:   request.Repository.FullName,
+		Tag:          version.Version,
+		Name:         version.Name,
+		Package:      pkg.Name,
+		PackageType:  pkg.PackageType,
+		ReleaseNotes: version.Body,
+		Author:       version.Author.Login,
+		URL:          version.URL,
+		Assets:       []ReleaseAsset{},
+	}
+	if len(metadata.Author) == 0 {
+		metadata.Author = request.Sender.Login
+	}
+	if len(metadata.URL) == 0 {
+		metadata.URL = pkg.URL
+	}
+	for _, file := range version.PackageFiles {
+		metadata.Assets = append(metadata.Assets, ReleaseAsset{Name: file.Name, URL: file.URL, Size: file.Size})
+	}
+
+	// Packages have no ref, so only rules without one match them.
+	s.recordRelease(w, r, request.Repository.FullName, "", &Event{
+		EventType: eventTypeAppRelease,
+		StartTime: version.CreatedAt,
+		Notes:     fmt.Sprintf("%s package %s %s published", pkg.PackageType, pkg.Name, version.Version),
+		Metadata:  metadata,
+	})
+}
+
+// recordRelease records an event from a release, tag or package webhook about
+// the repository and ref, unless the routing rules ignore it or its event type
+// has been unregistered.
+func (s *server) recordRelease(w http.ResponseWriter, r *http.Request, repository, ref string, event *Event) {
+	if !s.route(event, repository, ref) {
+		respondWithJSON(w, http.StatusOK, nil, "", event.Metadata)
+		return
+	} else if _, ok := s.eventTypes.Lookup(event.EventType); !ok {
+		respondWithJSON(w, http.StatusOK, nil, fmt.Sprintf("event type \"%s\" is not registered", event.EventType), event.Metadata)
+		return
+	}
+
+	if delivery := r.Header.Get(githubDeliverHeader); len(delivery) > 0 {
+		event.IdempotencyKey = "github:" + delivery
+	}
+	event.fromIntegration = true
+
+	if err := s.writeToDBAndLog(r.Context(), event); err != nil {
+		respondWithWriteError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", event)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReleaseHandler(t *testing.T) {
+	s := newTestServer(t)
+	slack := useFakeSlack(t, s, "#releases")
+
+	release := map[string]interface{}{
+		"action":     "published",
+		"repository": map[string]interface{}{"full_name": "octo/app"},
+		"release": map[string]interface{}{
+			"html_url":     "https://github.com/octo/app/releases/tag/v1.2.0",
+			"tag_name":     "v1.2.0",
+			"name":         "Spring release",
+			"published_at": "2024-03-01T12:00:00Z",
+			"author":       map[string]interface{}{"login": "octocat"},
+			"assets": []map[string]interface{}{
+				{"name": "app.tar.gz", "browser_download_url": "https://github.com/octo/app/releases/download/v1.2.0/app.tar.gz", "size": 1024},
+			},
+		},
+	}
+	expectStatus(t, s.serveGitHub(t, releaseEvent, "delivery-1", release), http.StatusOK)
+	// Redeliveries are recorded once.
+	expectStatus(t, s.serveGitHub(t, releaseEvent, "delivery-1", release), http.StatusOK)
+
+	events := storedEvents(t, s)
+	if len(events) != 1 || events[0].EventType != eventTypeAppRelease {
+		t.Fatalf("got %+v, want one APP RELEASE", events)
+	}
+	metadata := metadataOf(t, events[0])
+	if metadata["tag"] != "v1.2.0" || metadata["author"] != "octocat" || len(metadata["assets"].([]interface{})) != 1 {
+		t.Errorf("got metadata %v", metadata)
+	}
+
+	posted := slack.posted()
+	if len(posted) != 1 || !strings.Contains(posted[0], "octo/app v1.2.0 released by octocat") || !strings.Contains(posted[0], "app.tar.gz") {
+		t.Errorf("posted %q, want the release", posted)
+	}
+
+	// Drafts and other actions are not recorded.
+	release["action"] = "created"
+	expectStatus(t, s.serveGitHub(t, releaseEvent, "delivery-2", release), http.StatusOK)
+	if events := storedEvents(t, s); len(events) != 1 {
+		t.Errorf("got %d events, want 1", len(events))
+	}
+}
+
+func TestTagHandler(t *testing.T) {
+	s := newTestServer(t)
+	slack := useFakeSlack(t, s, "#releases")
+
+	for i, event := range []string{createEvent, deleteEvent} {
+		expectStatus(t, s.serveGitHub(t, event, event, map[string]interface{}{
+			"ref":        "v1.2.0",
+			"ref_type":   "tag",
+			"repository": map[string]interface{}{"full_name": "octo/app"},
+			"sender":     map[string]interface{}{"login": "octocat"},
+		}), http.StatusOK)
+		if events := storedEvents(t, s); len(events) != i+1 {
+			t.Fatalf("got %d events after %s, want %d", len(events), event, i+1)
+		}
+	}
+	expectStatus(t, s.serveGitHub(t, createEvent, "branch", map[string]interface{}{
+		"ref":        "feature",
+		"ref_type":   "branch",
+		"repository": map[string]interface{}{"full_name": "octo/app"},
+	}), http.StatusOK)
+
+	if events := storedEvents(t, s); len(events) != 2 {
+		t.Errorf("got %d events, want the two tags only", len(events))
+	}
+	if posted := slack.posted(); len(posted) != 2 ||
+		!strings.Contains(posted[0], "Tag v1.2.0 created in octo/app by octocat") ||
+		!strings.Contains(posted[1], "Tag v1.2.0 deleted from octo/app by octocat") {
+		t.Errorf("posted %q, want the tags", posted)
+	}
+}
+
+func TestReleaseSlackTemplateNeedsReleaseMetadata(t *testing.T) {
+	for _, test := range []struct {
+		metadata, template string
+	}{
+		{`{"github_event": "release", "action": "published", "assets": [{"name": "a", "url": "u"}]}`, "release"},
+		{`{"github_event": "release"}`, "release"},
+		{`{"github_event": "release", "action": 1}`, "default"},
+		{`{"github_event": "release", "assets": "app.tar.gz"}`, "default"},
+		{`{"github_event": "release", "assets": ["app.tar.gz"]}`, "default"},
+	} {
+		event := decodedEvent(t, eventTypeAppRelease, test.metadata)
+		if got := slackTemplateName(event); got != test.template {
+			t.Errorf("%s: got template %q, want %q", test.metadata, got, test.template)
+		}
+	}
+}
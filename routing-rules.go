@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// routingRulesRefreshRate bounds how long an edit to the routing rules file takes
+// to be noticed.
+const routingRulesRefreshRate = 10 * time.Second
+
+// RoutingRule decides how GitHub webhooks about matching repositories and refs
+// are recorded. Repository and Ref are globs as in path.Match, so "*" does not
+// match "/": "octo/*" matches every repository of octo, and "refs/heads/*" every
+// branch without a slash in its name. An empty Ref matches any ref, including
+// webhooks that have none, such as registry_package.
+type RoutingRule struct {
+	Repository string `yaml:"repository"`
+	Ref        string `yaml:"ref"`
+	// Record is true unless set to false, which ignores the matching webhooks.
+	Record *bool `yaml:"record"`
+	// EventType replaces the type that the webhook is normally recorded as.
+	EventType string `yaml:"event_type"`
+	// SlackChannel replaces the channel of the event type.
+	SlackChannel string `yaml:"slack_channel"`
+	// Services that the repository maps to are added to the metadata.
+	Services []string `yaml:"services"`
+}
+
+// Validate enforces minimum requirements for rules read from the rules file.
+func (r *RoutingRule) Validate() error {
+	if len(r.Repository) == 0 {
+		return fmt.Errorf("repository is required")
+	} else if _, err := path.Match(r.Repository, ""); err != nil {
+		return fmt.Errorf("repository \"%s\" is not a valid glob", r.Repository)
+	} else if _, err := path.Match(r.Ref, ""); err != nil {
+		return fmt.Errorf("ref \"%s\" is not a valid glob", r.Ref)
+	} else if strings.ToUpper(r.EventType) != r.EventType {
+		return fmt.Errorf("event_type must be an upper case event type name")
+	}
+	return nil
+}
+
+func (r *RoutingRule) matches(repository, ref string) bool {
+	if ok, _ := path.Match(strings.ToLower(r.Repository), strings.ToLower(repository)); !ok {
+		return false
+	}
+	if len(r.Ref) == 0 {
+		return true
+	}
+	ok, _ := path.Match(r.Ref, ref)
+	return ok
+}
+
+func (r *RoutingRule) records() bool {
+	return r.Record == nil || *r.Record
+}
+
+// apply changes the event as the rule says.
+func (r *RoutingRule) apply(event *Event) {
+	if len(r.EventType) > 0 {
+		event.EventType = r.EventType
+	}
+	event.slackChannel = r.SlackChannel
+
+	if len(r.Services) == 0 {
+		return
+	}
+	metadata := map[string]interface{}{}
+	if b, err := json.Marshal(event.Metadata); err == nil && json.Unmarshal(b, &metadata) == nil {
+		metadata["services"] = r.Services
+		event.Metadata = metadata
+	}
+}
+
+// RoutingRules are the rules in the file named by --github-routing-rules. The
+// first rule that matches a webhook applies. The file is read again whenever it
+// changes, and a file that fails to load leaves the previous rules in place.
+type RoutingRules struct {
+	path string
+
+	mu         sync.RWMutex
+	rules      []RoutingRule
+	modTime    time.Time
+	size       int64
+	checked    time.Time
+	refreshing sync.Mutex
+}
+
+func LoadRoutingRules(path string) (*RoutingRules, error) {
+	r := &RoutingRules{path: path}
+	return r, r.Refresh()
+}
+
+// Refresh reads the rules file again if it has changed.
+func (r *RoutingRules) Refresh() error {
+	r.refreshing.Lock()
+	defer r.refreshing.Unlock()
+
+	r.mu.Lock()
+	r.checked = time.Now()
+	modTime, size := r.modTime, r.size
+	r.mu.Unlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	} else if info.ModTime().Equal(modTime) && info.Size() == size {
+		return nil
+	}
+
+	rules, err := readRoutingRules(r.path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.rules = rules
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+	r.mu.Unlock()
+
+	slog.Info("loaded routing rules", "path", r.path, "rules", len(rules))
+	return nil
+}
+
+// Match returns the first rule that matches the repository and any of the refs.
+func (r *RoutingRules) Match(repository string, refs ...string) (RoutingRule, bool) {
+	r.mu.RLock()
+	age := time.Since(r.checked)
+	r.mu.RUnlock()
+
+	if age >= routingRulesRefreshRate {
+		if err := r.Refresh(); err != nil {
+			slog.Error("failed to reload routing rules, keeping the previous rules", "path", r.path, "error", err.Error())
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rule := range r.rules {
+		for _, ref := range refs {
+			if rule.matches(repository, ref) {
+				return rule, true
+			}
+		}
+	}
+	return RoutingRule{}, false
+}
+
+// readRoutingRules reads and validates a routing rules file.
+func readRoutingRules(path string) ([]RoutingRule, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := struct {
+		Rules []RoutingRule `yaml:"rules"`
+	}{}
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("routing rules %s: %w", path, err)
+	}
+
+	for i := range file.Rules {
+		if err := file.Rules[i].Validate(); err != nil {
+			return nil, fmt.Errorf("routing rules %s: rule %d: %w", path, i+1, err)
+		}
+	}
+
+	return file.Rules, nil
+}
+
+// route applies the routing rules to an event from a GitHub webhook about the
+// repository and ref, and reports whether the event should be recorded. A
+// webhook whose ref could be one of several refs is routed by the first rule
+// that matches any of them. Without routing rules every event is recorded as
+// is.
+func (s *server) route(event *Event, repository string, refs ...string) bool {
+	if s.routingRules == nil {
+		return true
+	}
+
+	rule, ok := s.routingRules.Match(repository, refs...)
+	if !ok || !rule.records() {
+		return false
+	}
+
+	rule.apply(event)
+	return true
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// writeRoutingRules writes a routing rules file to a temporary directory and
+// returns its path.
+func writeRoutingRules(t *testing.T, rules string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "routing-rules.yaml")
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testPush(ref, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"ref": ref,
+		"head_commit": map[string]interface{}{
+			"message":   message,
+			"timestamp": "2024-03-01T12:00:00Z",
+			"url":       "https://github.com/octo/app/commit/a10867b",
+		},
+		"repository": map[string]interface{}{"full_name": "octo/app", "default_branch": "main"},
+		"pusher":     map[string]interface{}{"name": "octocat"},
+	}
+}
+
+func TestRoutingRuleValidate(t *testing.T) {
+	for _, test := range []struct {
+		rule  RoutingRule
+		valid bool
+	}{
+		{RoutingRule{Repository: "octo/*"}, true},
+		{RoutingRule{Repository: "octo/app", Ref: "refs/heads/*", EventType: eventTypeOpsActivity}, true},
+		{RoutingRule{}, false},
+		{RoutingRule{Repository: "octo/["}, false},
+		{RoutingRule{Repository: "octo/app", Ref: "refs/heads/["}, false},
+		{RoutingRule{Repository: "octo/app", EventType: "ops activity"}, false},
+	} {
+		if err := test.rule.Validate(); (err == nil) != test.valid {
+			t.Errorf("%+v: got %v, want valid %t", test.rule, err, test.valid)
+		}
+	}
+}
+
+func TestRoutingRuleMatches(t *testing.T) {
+	for _, test := range []struct {
+		rule            RoutingRule
+		repository, ref string
+		matches         bool
+	}{
+		{RoutingRule{Repository: "octo/*"}, "Octo/App", "refs/heads/main", true},
+		{RoutingRule{Repository: "octo/*"}, "octo/app", "", true},
+		{RoutingRule{Repository: "octo/*"}, "someone/app", "refs/heads/main", false},
+		{RoutingRule{Repository: "*"}, "octo/app", "refs/heads/main", false},
+		{RoutingRule{Repository: "octo/app", Ref: "refs/heads/*"}, "octo/app", "refs/heads/main", true},
+		{RoutingRule{Repository: "octo/app", Ref: "refs/heads/*"}, "octo/app", "refs/heads/feature/x", false},
+		{RoutingRule{Repository: "octo/app", Ref: "refs/heads/*"}, "octo/app", "refs/tags/v1", false},
+		{RoutingRule{Repository: "octo/app", Ref: "refs/heads/*"}, "octo/app", "", false},
+	} {
+		if got := test.rule.matches(test.repository, test.ref); got != test.matches {
+			t.Errorf("%+v matching %s %q: got %t, want %t", test.rule, test.repository, test.ref, got, test.matches)
+		}
+	}
+}
+
+func TestRoutingRuleApply(t *testing.T) {
+	event := &Event{EventType: eventTypePush, Metadata: PushData{Ref: "refs/heads/main"}}
+	rule := RoutingRule{
+		Repository:   "octo/app",
+		EventType:    eventTypeOpsActivity,
+		SlackChannel: "#app-deploys",
+		Services:     []string{"app-web", "app-api"},
+	}
+	rule.apply(event)
+
+	if event.EventType != eventTypeOpsActivity || event.slackChannel != "#app-deploys" {
+		t.Errorf("got event type %q and channel %q", event.EventType, event.slackChannel)
+	}
+	metadata, ok := event.Metadata.(map[string]interface{})
+	if !ok {
+		t.Fatalf("got metadata %T, want an object", event.Metadata)
+	} else if metadata["ref"] != "refs/heads/main" || !reflect.DeepEqual(metadata["services"], []string{"app-web", "app-api"}) {
+		t.Errorf("got metadata %v, want the push with the services", metadata)
+	}
+
+	// Without services the metadata is left as it was.
+	event = &Event{EventType: eventTypePush, Metadata: PushData{}}
+	(&RoutingRule{Repository: "octo/app"}).apply(event)
+	if _, ok := event.Metadata.(PushData); !ok || event.EventType != eventTypePush {
+		t.Errorf("got %+v, want the event unchanged", event)
+	}
+}
+
+func TestRoutingRules(t *testing.T) {
+	path := writeRoutingRules(t, `
+rules:
+  - repository: octo/app
+    ref: refs/heads/main
+    event_type: OPS ACTIVITY
+  - repository: octo/app
+    record: false
+  - repository: octo/*
+`)
+	rules, err := LoadRoutingRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		repository, ref string
+		matches, record bool
+		eventType       string
+	}{
+		{"octo/app", "refs/heads/main", true, true, eventTypeOpsActivity},
+		{"octo/app", "refs/heads/feature", true, false, ""},
+		{"octo/api", "refs/heads/feature", true, true, ""},
+		{"someone/app", "refs/heads/main", false, false, ""},
+	} {
+		rule, ok := rules.Match(test.repository, test.ref)
+		if ok != test.matches || (ok && (rule.records() != test.record || rule.EventType != test.eventType)) {
+			t.Errorf("%s %s: got %+v, %t", test.repository, test.ref, rule, ok)
+		}
+	}
+
+	// An invalid file leaves the previous rules in place, and a valid one
+	// replaces them.
+	if err := os.WriteFile(path, []byte("rules:\n  - ref: refs/heads/main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	} else if err := rules.Refresh(); err == nil {
+		t.Error("a rule without a repository was accepted")
+	} else if _, ok := rules.Match("octo/api", "refs/heads/main"); !ok {
+		t.Error("the previous rules were dropped")
+	}
+	if err := os.WriteFile(path, []byte("rules:\n  - repository: someone/*\n"), 0o644); err != nil {
+		t.Fatal(err)
+	} else if err := rules.Refresh(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := rules.Match("octo/api", "refs/heads/main"); ok {
+		t.Error("the changed rules were not loaded")
+	} else if _, ok := rules.Match("someone/app", "refs/heads/main"); !ok {
+		t.Error("the changed rules were not loaded")
+	}
+
+	if _, err := LoadRoutingRules(writeRoutingRules(t, "rules:\n  - repository: octo/app\n    channel: \"#x\"\n")); err == nil {
+		t.Error("a rule with an unknown field was accepted")
+	}
+}
+
+func TestPushHandlerRoutingRules(t *testing.T) {
+	s := newTestServer(t)
+	slack := useFakeSlack(t, s, "#events")
+
+	// Without routing rules, only pushes to the default branch are recorded.
+	expectStatus(t, s.serveGitHub(t, pushEvent, "p1", testPush("refs/heads/feature", "Start feature")), http.StatusOK)
+	expectStatus(t, s.serveGitHub(t, pushEvent, "p2", testPush("refs/heads/main", "Fix typo")), http.StatusOK)
+	if events := storedEvents(t, s); len(events) != 1 || events[0].EventType != eventTypePush {
+		t.Fatalf("got %+v, want one PUSH to main", events)
+	}
+
+	var err error
+	if s.routingRules, err = LoadRoutingRules(writeRoutingRules(t, `
+rules:
+  - repository: octo/app
+    ref: refs/heads/release
+    event_type: DEPLOYMENT
+    slack_channel: "#app-deploys"
+    services: [app-web]
+  - repository: octo/app
+    ref: refs/heads/main
+    record: false
+  - repository: octo/app
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The DEPLOYMENT schema does not apply to the push, which has no type or
+	// machines.
+	expectStatus(t, s.serveGitHub(t, pushEvent, "p3", testPush("refs/heads/release", "Release")), http.StatusOK)
+	expectStatus(t, s.serveGitHub(t, pushEvent, "p4", testPush("refs/heads/main", "Ignored")), http.StatusOK)
+	expectStatus(t, s.serveGitHub(t, pushEvent, "p5", testPush("refs/heads/feature", "Continue feature")), http.StatusOK)
+	// Deleting a branch is not a push of a commit, even if a rule matches it.
+	deletion := testPush("refs/heads/release", "")
+	deletion["deleted"] = true
+	deletion["head_commit"] = nil
+	expectStatus(t, s.serveGitHub(t, pushEvent, "p6", deletion), http.StatusOK)
+
+	events := storedEvents(t, s)
+	if len(events) != 3 || events[1].EventType != eventTypeDeployment || events[2].EventType != eventTypePush {
+		t.Fatalf("got %+v, want the release push as a DEPLOYMENT and the feature push", events)
+	} else if metadata := metadataOf(t, events[1]); !reflect.DeepEqual(metadata["services"], []interface{}{"app-web"}) {
+		t.Errorf("got metadata %v, want the services", metadata)
+	}
+	if len(slack.messages) != 3 || slack.messages[1].Channel != "#app-deploys" || slack.messages[2].Channel != "#events" {
+		t.Errorf("posted %+v, want the DEPLOYMENT in #app-deploys", slack.messages)
+	}
+}
+
+func TestGitHubHandlersRejectInvalidEvents(t *testing.T) {
+	s := newTestServer(t)
+
+	// A push or merge without a message has no notes.
+	w := s.serveGitHub(t, pushEvent, "p1", testPush("refs/heads/main", ""))
+	expectStatus(t, w, http.StatusBadRequest)
+	if response := decodeResponse(t, w, nil); response.Error == "" {
+		t.Error("the response has no error")
+	}
+
+	expectStatus(t, s.serveGitHub(t, pullRequestEvent, "m1", map[string]interface{}{
+		"action": "closed",
+		"number": 7,
+		"pull_request": map[string]interface{}{
+			"merged":     true,
+			"title":      "",
+			"updated_at": "2024-03-01T12:00:00Z",
+			"base":       map[string]interface{}{"ref": "main"},
+		},
+		"repository": map[string]interface{}{"full_name": "octo/app"},
+	}), http.StatusBadRequest)
+
+	if events := storedEvents(t, s); len(events) != 0 {
+		t.Errorf("got %d events, want none", len(events))
+	}
+}
+
+func TestDeploymentHandlerRoutingRules(t *testing.T) {
+	s := newTestServer(t)
+	slack := useFakeSlack(t, s, "#events")
+
+	var err error
+	if s.routingRules, err = LoadRoutingRules(writeRoutingRules(t, `
+rules:
+  - repository: octo/app
+    ref: refs/heads/main
+    slack_channel: "#app-deploys"
+  - repository: octo/app
+    ref: refs/tags/v*
+    slack_channel: "#app-releases"
+  - repository: octo/app
+    record: false
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// GitHub gives the ref as it was passed to the deployment.
+	for i, ref := range []string{"main", "v1.2.0", "feature", "refs/heads/main"} {
+		deployment := testGitHubDeployment(int64(i))
+		deployment["ref"] = ref
+		expectStatus(t, s.serveGitHub(t, deploymentEvent, "d"+strconv.Itoa(i), map[string]interface{}{
+			"action":     "created",
+			"repository": map[string]interface{}{"full_name": "octo/app"},
+			"deployment": deployment,
+		}), http.StatusOK)
+	}
+
+	if events := storedEvents(t, s); len(events) != 3 {
+		t.Fatalf("got %d events, want every deployment but the feature branch's", len(events))
+	}
+	channels := []string{}
+	for _, message := range slack.messages {
+		channels = append(channels, message.Channel)
+	}
+	if !reflect.DeepEqual(channels, []string{"#app-deploys", "#app-releases", "#app-deploys"}) {
+		t.Errorf("posted to %q, want the branch and tag deployments in their channels", channels)
+	}
+}
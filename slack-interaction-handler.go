@@ -5,7 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
 	"time"
@@ -21,7 +21,7 @@ func (s *server) slackInteractionResponse(channel string, message string) {
 	request := slack.NewChatPostMessageRequest(channel)
 	request.Text = message
 	if _, err := s.SlackClient.ChatPostMessage(request); err != nil {
-		log.Printf("Failed to post message with error: %s", err.Error())
+		slog.Error("failed to post Slack interaction response", "channel", channel, "error", err.Error())
 	}
 }
 
@@ -41,14 +41,17 @@ func (s *server) slackInteractionEphemeralResponse(url string, message string) {
 	)
 
 	if err != nil {
-		log.Printf("Failed to generate HTTP request with error: %s", err.Error())
+		slog.Error("failed to generate Slack ephemeral response", "error", err.Error())
+		return
 	}
 
 	httpClient := &http.Client{}
-	_, err = httpClient.Do(httpRequest)
+	response, err := httpClient.Do(httpRequest)
 	if err != nil {
-		log.Printf("Failed to post message with error: %s", err.Error())
+		slog.Error("failed to post Slack ephemeral response", "error", err.Error())
+		return
 	}
+	response.Body.Close()
 }
 
 // SlackInteractionData is a partial representation of the request payload used to
@@ -85,7 +88,7 @@ func (r *SlackInteractionData) Validate() error {
 }
 
 func (request *SlackInteractionData) ParseState(tzOffsetSeconds int) (*Event, error) {
-	event := Event{EventType: "INCIDENT", DryRun: true}
+	event := Event{EventType: eventTypeIncident, DryRun: true}
 	var startDate string
 	var startTime string
 	var endDate string
@@ -174,7 +177,7 @@ func (request *SlackInteractionData) ParseState(tzOffsetSeconds int) (*Event, er
 		return nil, fmt.Errorf("Bad start date and/or time")
 	}
 
-	log.Printf("Location: %s", event.StartTime.Location().String())
+	slog.Debug("parsed interaction start time", "location", event.StartTime.Location().String())
 	err = event.EndTime.UnmarshalJSON([]byte(fmt.Sprintf(`"%sT%s:00%s%02d:%02d"`, endDate, endTime, sign, hours, minutes)))
 	if err != nil {
 		return nil, fmt.Errorf("Failed to parse timestamp for end time: %w", err)
@@ -238,7 +241,7 @@ func (s *server) SlackInteractionHandler(w http.ResponseWriter, r *http.Request)
 	// Generate a Slack message as a response to the user's interaction.
 	eventBytes, err := json.MarshalIndent(&event, "", "  ")
 	if err != nil {
-		log.Printf("Failed to marshal event: %s", err.Error())
+		logger(r.Context()).Error("failed to marshal event for Slack response", "id", event.ID, "error", err.Error())
 	}
 
 	// Send the Slack message asyncronously.
@@ -1,13 +1,223 @@
 package main
 
-import "text/template"
+import (
+	"strings"
+	"text/template"
+)
 
+// slackTemplate holds a template per producer of metadata, named after the
+// entries of slackMessages, and the "default" template for everything else.
 var slackTemplate = template.Must(template.New("").Parse(`
-{{if eq .EventType "PULL REQUEST"}}
+{{define "merge" -}}
 *PR merged into {{.Metadata.repository.full_name}} by {{.Metadata.pull_request.user.login}} at {{.StartTime.Format "Mon, 02 Jan 2006 15:04:05 MST"}}*
 <{{.Metadata.pull_request.html_url}}|{{.Metadata.pull_request.title}}>
 {{.Metadata.pull_request.body}}
-{{else}}
+{{end}}
+
+{{define "release" -}}
+{{- if eq .Metadata.action "deleted"}}
+*Tag {{.Metadata.tag}} deleted from {{.Metadata.repository}} by {{.Metadata.author}} at {{.StartTime.Format "Mon, 02 Jan 2006 15:04:05 MST"}}*
+{{- else if eq .Metadata.action "created"}}
+*Tag {{.Metadata.tag}} created in {{.Metadata.repository}} by {{.Metadata.author}} at {{.StartTime.Format "Mon, 02 Jan 2006 15:04:05 MST"}}*
+{{- else}}
+*{{with .Metadata.package}}{{.}} {{else}}{{.Metadata.repository}} {{end}}{{.Metadata.tag}}{{if .Metadata.prerelease}} (pre-release){{end}} released by {{.Metadata.author}} at {{.StartTime.Format "Mon, 02 Jan 2006 15:04:05 MST"}}*
+{{- with .Metadata.url}}
+<{{.}}|{{with $.Metadata.name}}{{.}}{{else}}{{$.Metadata.tag}}{{end}}>
+{{- end}}
+{{- with .Metadata.release_notes}}
+{{.}}
+{{- end}}
+{{- with .Metadata.assets}}
+Assets:{{range .}} <{{.url}}|{{.name}}>{{end}}
+{{- end}}
+{{- end}}
+{{end}}
+
+{{define "workflow_run" -}}
+{{- if or (eq .Metadata.conclusion "failure") (eq .Metadata.conclusion "timed_out") (eq .Metadata.conclusion "startup_failure")}}
+*FAILED: {{.Metadata.workflow}} #{{printf "%.0f" .Metadata.run_number}} in {{.Metadata.repository}} ({{.Metadata.conclusion}})*
+Started by {{.Metadata.actor}} on {{.Metadata.head_branch}} ({{printf "%.7s" .Metadata.head_sha}}) at {{.StartTime.Format "Mon, 02 Jan 2006 15:04:05 MST"}}
+<{{.Metadata.url}}|See the failed run>
+{{- else}}
+*{{.Metadata.workflow}} #{{printf "%.0f" .Metadata.run_number}} in {{.Metadata.repository}}: {{.Metadata.conclusion}}*
+Started by {{.Metadata.actor}} on {{.Metadata.head_branch}} ({{printf "%.7s" .Metadata.head_sha}}) at {{.StartTime.Format "Mon, 02 Jan 2006 15:04:05 MST"}}
+<{{.Metadata.url}}|See the run>
+{{- end}}
+{{end}}
+
+{{define "deployment" -}}
+*Deployment of {{.Metadata.repository}}@{{.Metadata.ref}} ({{printf "%.7s" .Metadata.sha}}) to {{.Metadata.environment}} by {{.Metadata.creator}}: {{.Metadata.state}}*
+{{- with .Metadata.status_description}}
+{{.}}
+{{- end}}
+{{- with .Metadata.status_url}}
+<{{.}}|Deployment log>
+{{- end}}
+{{end}}
+
+{{define "vcs" -}}
+{{- if or (eq .Metadata.vcs_event "merge_request") (eq .Metadata.vcs_event "pull_request")}}
+*{{if eq .Metadata.vcs_event "merge_request"}}MR{{else}}PR{{end}} merged into {{.Metadata.repository}} by {{.Metadata.author}} at {{.StartTime.Format "Mon, 02 Jan 2006 15:04:05 MST"}}*
+<{{.Metadata.url}}|{{.Metadata.title}}>
+{{.Metadata.description}}
+{{- else if eq .Metadata.vcs_event "tag_push"}}
+*Tag {{.Metadata.tag}} {{.Metadata.action}} {{if eq .Metadata.action "deleted"}}from{{else}}in{{end}} {{.Metadata.repository}} by {{.Metadata.author}} at {{.StartTime.Format "Mon, 02 Jan 2006 15:04:05 MST"}}*
+{{- else if eq .Metadata.vcs_event "deployment"}}
+*Deployment of {{.Metadata.repository}}@{{.Metadata.ref}} ({{printf "%.7s" .Metadata.sha}}) to {{.Metadata.environment}} by {{.Metadata.author}}: {{.Metadata.state}}*
+{{- with .Metadata.url}}
+<{{.}}|Deployment log>
+{{- end}}
+{{- else if eq .Metadata.vcs_event "pipeline"}}
+{{- if eq .Metadata.state "failed"}}
+*FAILED: {{with .Metadata.name}}{{.}}{{else}}Pipeline{{end}} #{{printf "%.0f" .Metadata.id}} in {{.Metadata.repository}} ({{.Metadata.state}})*
+Started by {{.Metadata.author}} on {{.Metadata.ref}} ({{printf "%.7s" .Metadata.sha}}) at {{.StartTime.Format "Mon, 02 Jan 2006 15:04:05 MST"}}
+<{{.Metadata.url}}|See the failed run>
+{{- else}}
+*{{with .Metadata.name}}{{.}}{{else}}Pipeline{{end}} #{{printf "%.0f" .Metadata.id}} in {{.Metadata.repository}}: {{.Metadata.state}}*
+Started by {{.Metadata.author}} on {{.Metadata.ref}} ({{printf "%.7s" .Metadata.sha}}) at {{.StartTime.Format "Mon, 02 Jan 2006 15:04:05 MST"}}
+<{{.Metadata.url}}|See the run>
+{{- end}}
+{{- else}}
+*Pushed to {{.Metadata.repository}} {{.Metadata.ref}} by {{.Metadata.author}} at {{.StartTime.Format "Mon, 02 Jan 2006 15:04:05 MST"}}*
+{{- range .Metadata.commits}}
+<{{.url}}|{{printf "%.7s" .sha}}> {{.message}}
+{{- end}}
+{{- end}}
+{{end}}
+
+{{define "default" -}}
 ` + "```{{.MarshalString}}```" + `
 {{end}}
 `))
+
+// slackMessage describes the metadata a template in slackTemplate expects. Any
+// client can record any metadata, so it is checked before picking the template
+// rather than failing in the middle of executing it. Fields are dotted paths.
+type slackMessage struct {
+	template   string
+	eventTypes []string
+	// key identifies the metadata's producer and must be set.
+	key string
+	// objects must be JSON objects.
+	objects []string
+	// strings are compared to strings, so must be strings if they are set.
+	strings []string
+	// lists are ranged over, so must be arrays of objects if they are set.
+	lists []string
+}
+
+// slackMessages are tried in order.
+var slackMessages = []slackMessage{
+	{
+		template:   "merge",
+		eventTypes: []string{eventTypeMerge},
+		key:        "pull_request",
+		objects:    []string{"repository", "pull_request", "pull_request.user"},
+	},
+	{
+		template:   "release",
+		eventTypes: []string{eventTypeAppRelease},
+		key:        "github_event",
+		strings:    []string{"action"},
+		lists:      []string{"assets"},
+	},
+	{
+		template:   "workflow_run",
+		eventTypes: []string{eventTypeDeployment, eventTypeOpsActivity},
+		key:        "run_id",
+		strings:    []string{"conclusion"},
+	},
+	{
+		template:   "deployment",
+		eventTypes: []string{eventTypeDeployment},
+		key:        "deployment_id",
+	},
+	{
+		template:   "vcs",
+		eventTypes: []string{eventTypePush, eventTypeMerge, eventTypeAppRelease, eventTypeDeployment, eventTypeOpsActivity},
+		key:        "vcs",
+		strings:    []string{"vcs_event", "action", "state"},
+		lists:      []string{"commits"},
+	},
+}
+
+// slackTemplateName returns the name of the template in slackTemplate for the
+// event, whose metadata must have been decoded from JSON.
+func slackTemplateName(event *Event) string {
+	metadata, ok := event.Metadata.(map[string]interface{})
+	if !ok {
+		return "default"
+	}
+
+	for _, message := range slackMessages {
+		if message.matches(event.EventType, metadata) {
+			return message.template
+		}
+	}
+
+	return "default"
+}
+
+func (m *slackMessage) matches(eventType string, metadata map[string]interface{}) bool {
+	matchesType := false
+	for _, t := range m.eventTypes {
+		matchesType = matchesType || t == eventType
+	}
+	if !matchesType {
+		return false
+	} else if value, ok := metadataField(metadata, m.key); !ok {
+		return false
+	} else if truth, _ := template.IsTrue(value); !truth {
+		return false
+	}
+
+	for _, field := range m.objects {
+		if value, _ := metadataField(metadata, field); !isJSONObject(value) {
+			return false
+		}
+	}
+	for _, field := range m.strings {
+		if value, ok := metadataField(metadata, field); ok && value != nil {
+			if _, ok := value.(string); !ok {
+				return false
+			}
+		}
+	}
+	for _, field := range m.lists {
+		value, ok := metadataField(metadata, field)
+		if !ok || value == nil {
+			continue
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range items {
+			if !isJSONObject(item) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// metadataField returns the value at the dotted path in metadata.
+func metadataField(metadata map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = metadata
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func isJSONObject(value interface{}) bool {
+	_, ok := value.(map[string]interface{})
+	return ok
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// decodedEvent returns an event with the metadata decoded from JSON, as
+// logToSlackChannel passes it to the template.
+func decodedEvent(t *testing.T, eventType, metadata string) *Event {
+	t.Helper()
+
+	event := &Event{ID: 1, EventType: eventType, Notes: "x", StartTime: time.Now()}
+	if err := json.Unmarshal([]byte(metadata), &event.Metadata); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestSlackTemplateName(t *testing.T) {
+	for _, test := range []struct {
+		eventType, metadata, template string
+	}{
+		{eventTypeMerge, `null`, "default"},
+		{eventTypeMerge, `"pull_request"`, "default"},
+		{eventTypeMerge, `[{"pull_request": {}}]`, "default"},
+		{eventTypeMerge, `{"pull_request": "x", "repository": {}}`, "default"},
+		{eventTypeMerge, `{"pull_request": {"user": "octocat"}, "repository": {}}`, "default"},
+		{eventTypeMerge, `{"pull_request": {"user": {"login": "octocat"}}}`, "default"},
+		{eventTypeMerge, `{"pull_request": {"user": {"login": "octocat"}}, "repository": {"full_name": "a/b"}}`, "merge"},
+		{eventTypePush, `{"pull_request": {"user": {"login": "octocat"}}, "repository": {"full_name": "a/b"}}`, "default"},
+		{eventTypeDeployment, `{"run_id": 0}`, "default"},
+		{eventTypeDeployment, `{"run_id": 1}`, "workflow_run"},
+		{eventTypeDeployment, `{"deployment_id": 1}`, "deployment"},
+		{eventTypePush, `{"vcs": "gitlab", "commits": "abc"}`, "default"},
+		{eventTypePush, `{"vcs": "gitlab", "commits": [{"sha": "abc"}]}`, "vcs"},
+		{eventTypePush, `{"vcs": "gitlab", "vcs_event": 1}`, "default"},
+	} {
+		event := decodedEvent(t, test.eventType, test.metadata)
+		if got := slackTemplateName(event); got != test.template {
+			t.Errorf("%s %s: got template %q, want %q", test.eventType, test.metadata, got, test.template)
+		}
+	}
+}
+
+func TestSlackTemplateExecutesForAnyMetadata(t *testing.T) {
+	for _, metadata := range []string{
+		`null`,
+		`"text"`,
+		`1`,
+		`[]`,
+		`{}`,
+		`{"pull_request": "x"}`,
+		`{"pull_request": {"user": null}, "repository": null}`,
+		`{"run_id": 1}`,
+		`{"deployment_id": 1}`,
+		`{"vcs": "gitlab"}`,
+		`{"vcs": "gitlab", "commits": ["abc"]}`,
+		`{"vcs": "gitlab", "vcs_event": "tag_push", "action": ["created"]}`,
+	} {
+		for _, eventType := range defaultEventTypes {
+			event := decodedEvent(t, eventType.Name, metadata)
+			if err := slackTemplate.ExecuteTemplate(&bytes.Buffer{}, slackTemplateName(event), event); err != nil {
+				t.Errorf("%s %s: %v", eventType.Name, metadata, err)
+			}
+		}
+	}
+}
+
+func TestRecordHandlerPostsToSlack(t *testing.T) {
+	s := newTestServer(t)
+	slack := useFakeSlack(t, s, "#events")
+
+	w := s.serve(t, http.MethodPost, "/api/v0/record", map[string]interface{}{
+		"event_type": eventTypeMerge,
+		"notes":      "merged without metadata",
+	}, nil)
+	expectStatus(t, w, http.StatusOK)
+
+	if posted := slack.posted(); len(posted) != 1 || !strings.Contains(posted[0], "merged without metadata") {
+		t.Errorf("posted %q, want the event", posted)
+	}
+}
+
+func TestRecordHandlerSucceedsWhenSlackFails(t *testing.T) {
+	s := newTestServer(t)
+	slack := useFakeSlack(t, s, "#events")
+	slack.fail = true
+
+	failures := testutil.ToFloat64(slackPostFailuresTotal)
+	w := s.serve(t, http.MethodPost, "/api/v0/record", map[string]interface{}{
+		"event_type": eventTypeOpsActivity,
+		"notes":      "restarted the api",
+	}, nil)
+	expectStatus(t, w, http.StatusOK)
+
+	if events := storedEvents(t, s); len(events) != 1 {
+		t.Errorf("got %d events, want 1", len(events))
+	}
+	if got := testutil.ToFloat64(slackPostFailuresTotal); got != failures+1 {
+		t.Errorf("slack_post_failures_total went from %v to %v, want one more", failures, got)
+	}
+}
@@ -10,6 +10,10 @@ import (
 	"time"
 
 	"github.com/gorilla/schema"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type SlackMethod string
@@ -40,12 +44,21 @@ const (
 	HeaderAuthorization   Header      = "Authorization"
 )
 
+var tracer = otel.Tracer("makeshift.dev/event-tracker/slack")
+
 type Client struct {
-	token string
+	token      string
+	httpClient *http.Client
 }
 
 func New(token string) *Client {
-	return &Client{token: token}
+	return NewWithHTTPClient(token, &http.Client{})
+}
+
+// NewWithHTTPClient returns a client that sends its requests through
+// httpClient, e.g. one whose transport stands in for the Slack API in tests.
+func NewWithHTTPClient(token string, httpClient *http.Client) *Client {
+	return &Client{token: token, httpClient: httpClient}
 }
 
 type ChatPostMessageRequest struct {
@@ -142,15 +155,33 @@ type Response interface {
 	GetError() string
 }
 
-func (c *Client) doRequest(request *http.Request, response Response, method SlackMethod) error {
+func (c *Client) doRequest(request *http.Request, response Response, method SlackMethod) (err error) {
+	ctx, span := tracer.Start(request.Context(), "slack"+method.String(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", request.Method),
+			attribute.String("http.url", apiURL+method.String()),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	request = request.WithContext(ctx)
+
 	request.Header.Set(HeaderAuthorization.String(), fmt.Sprintf("Bearer %s", c.token))
 
-	httpClient := &http.Client{}
-	httpResponse, err := httpClient.Do(request)
+	httpResponse, err := c.httpClient.Do(request)
 
 	if err != nil {
 		return err
 	}
+	defer httpResponse.Body.Close()
+
+	span.SetAttributes(attribute.Int("http.status_code", httpResponse.StatusCode))
 
 	// Make sure the requests was sucessful and log the response if the request failed.
 	if httpResponse.StatusCode != http.StatusOK {
@@ -171,10 +202,15 @@ func (c *Client) doRequest(request *http.Request, response Response, method Slac
 
 // https://api.slack.com/methods/chat.postMessage
 func (c *Client) ChatPostMessage(request *ChatPostMessageRequest) (*ChatPostMessageResponse, error) {
+	return c.ChatPostMessageContext(context.Background(), request)
+}
+
+// ChatPostMessageContext is ChatPostMessage as part of the operation in ctx.
+func (c *Client) ChatPostMessageContext(ctx context.Context, request *ChatPostMessageRequest) (*ChatPostMessageResponse, error) {
 	requestBody, err := json.MarshalIndent(&request, "", "  ")
 
 	// Set a context with a 10s timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Generate the request.
@@ -330,6 +366,11 @@ func (c *UsersInfoResponse) GetError() string {
 
 // https://api.slack.com/methods/users.info
 func (c *Client) UsersInfo(request *UsersInfoRequest) (*UsersInfoResponse, error) {
+	return c.UsersInfoContext(context.Background(), request)
+}
+
+// UsersInfoContext is UsersInfo as part of the operation in ctx.
+func (c *Client) UsersInfoContext(ctx context.Context, request *UsersInfoRequest) (*UsersInfoResponse, error) {
 	values := url.Values{}
 	encoder := schema.NewEncoder()
 	if err := encoder.Encode(request, values); err != nil {
@@ -337,7 +378,7 @@ func (c *Client) UsersInfo(request *UsersInfoRequest) (*UsersInfoResponse, error
 	}
 
 	// Set a context with a 10s timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Generate the request.
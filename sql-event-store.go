@@ -0,0 +1,647 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sqlEventStore implements EventStore on top of any database/sql driver that
+// uses "?" placeholders.
+type sqlEventStore struct {
+	db *sql.DB
+	// lockClause is appended to a SELECT that is followed by an UPDATE in the same
+	// transaction.
+	lockClause string
+	// isDuplicateKey reports whether an error is a primary key violation.
+	isDuplicateKey func(err error) bool
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanEvent reads a row selected as id, event_type, start_time, end_time, notes,
+// metadata, api_key_id, from_integration.
+func scanEvent(row rowScanner) (*Event, error) {
+	event := &Event{}
+	var notes sql.NullString
+	var metadata []byte
+	var apiKeyID sql.NullString
+	if err := row.Scan(
+		&event.ID,
+		&event.EventType,
+		&event.StartTime,
+		&event.EndTime,
+		&notes,
+		&metadata,
+		&apiKeyID,
+		&event.fromIntegration,
+	); err != nil {
+		return nil, err
+	}
+
+	event.Notes = notes.String
+	event.APIKeyID = apiKeyID.String
+	if len(metadata) > 0 {
+		event.Metadata = json.RawMessage(metadata)
+	}
+
+	return event, nil
+}
+
+// nullableString stores empty strings as NULL.
+func nullableString(s string) interface{} {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+// nullableJSON stores empty JSON documents as NULL.
+func nullableJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+func (s *sqlEventStore) Insert(ctx context.Context, event *Event) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.insert(ctx, tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlEventStore) InsertBatch(ctx context.Context, events []*Event, atomic bool) ([]error, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Each event is inserted under a savepoint. insert claims the idempotency key
+	// before adding the event, and a failed statement only undoes itself, so
+	// without one a failed event would leave its key claimed in the committed
+	// transaction.
+	errs := make([]error, len(events))
+	failed := false
+	for i, event := range events {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT event_insert"); err != nil {
+			return nil, err
+		}
+		if errs[i] = s.insert(ctx, tx, event); errs[i] != nil {
+			failed = true
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT event_insert"); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT event_insert"); err != nil {
+			return nil, err
+		}
+	}
+
+	if atomic && failed {
+		return errs, nil
+	}
+
+	return errs, tx.Commit()
+}
+
+// insert adds the event and claims its idempotency key within tx. If it fails,
+// the key may still be claimed, so tx must be rolled back, at least to a
+// savepoint taken before the call.
+func (s *sqlEventStore) insert(ctx context.Context, tx *sql.Tx, event *Event) error {
+	metadata, err := json.Marshal(event.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata to []byte")
+	}
+
+	endTime := event.EndTime
+	endTime.Time = endTime.Time.UTC()
+
+	// Claim the idempotency key first so that a duplicate key is not mistaken for
+	// a duplicate event ID.
+	if len(event.IdempotencyKey) > 0 {
+		_, err := tx.ExecContext(ctx, `
+INSERT INTO idempotency_keys (
+	idempotency_key,
+	event_id
+) VALUES (
+	?,
+	?
+)
+`, event.IdempotencyKey, event.ID)
+		if err != nil && s.isDuplicateKey(err) {
+			return errDuplicateKey
+		} else if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO events (
+	id,
+	event_type,
+	start_time,
+	end_time,
+	notes,
+	metadata,
+	api_key_id,
+	from_integration
+) VALUES (
+	?,
+	?,
+	?,
+	?,
+	?,
+	?,
+	?,
+	?
+)
+`, event.ID, event.EventType, event.StartTime.UTC(), endTime, event.Notes, metadata, nullableString(event.APIKeyID), event.fromIntegration)
+	if err != nil && s.isDuplicateKey(err) {
+		return errDuplicateEventID
+	}
+
+	return err
+}
+
+func (s *sqlEventStore) Get(ctx context.Context, id int64) (*Event, error) {
+	row := s.db.QueryRowContext(ctx, `
+SELECT
+	id,
+	event_type,
+	start_time,
+	end_time,
+	notes,
+	metadata,
+	api_key_id,
+	from_integration
+FROM events
+WHERE id = ? AND delete_time IS NULL
+`, id)
+
+	event, err := scanEvent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errEventNotFound
+	}
+	return event, err
+}
+
+func (s *sqlEventStore) GetByIdempotencyKey(ctx context.Context, key string) (*Event, error) {
+	row := s.db.QueryRowContext(ctx, `
+SELECT
+	events.id,
+	events.event_type,
+	events.start_time,
+	events.end_time,
+	events.notes,
+	events.metadata,
+	events.api_key_id,
+	events.from_integration
+FROM idempotency_keys
+JOIN events ON events.id = idempotency_keys.event_id
+WHERE idempotency_keys.idempotency_key = ?
+`, key)
+
+	event, err := scanEvent(row)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return event, err
+	}
+
+	// Tell a key that was never used from one whose event is missing.
+	var id int64
+	err = s.db.QueryRowContext(ctx, `
+SELECT event_id
+FROM idempotency_keys
+WHERE idempotency_key = ?
+`, key).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errEventNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return nil, errOrphanedKey
+}
+
+// selectEvents builds a SELECT for the events matching q, in the requested
+// order, starting after q's cursor.
+func selectEvents(q *EventsQuery) (string, []interface{}) {
+	where := []string{"delete_time IS NULL"}
+	args := []interface{}{}
+
+	if len(q.EventTypes) > 0 {
+		placeholders := make([]string, len(q.EventTypes))
+		for i, eventType := range q.EventTypes {
+			placeholders[i] = "?"
+			args = append(args, eventType)
+		}
+		where = append(where, fmt.Sprintf("event_type IN (%s)", strings.Join(placeholders, ", ")))
+	}
+
+	if !q.Start.IsZero() {
+		where = append(where, "start_time >= ?")
+		args = append(args, q.Start.UTC())
+	}
+
+	if !q.End.IsZero() {
+		where = append(where, "start_time < ?")
+		args = append(args, q.End.UTC())
+	}
+
+	if len(q.Notes) > 0 {
+		where = append(where, "notes LIKE ?")
+		args = append(args, "%"+q.Notes+"%")
+	}
+
+	comparison := "<"
+	if q.Order == orderAscending {
+		comparison = ">"
+	}
+
+	if q.cursor != nil {
+		where = append(where, fmt.Sprintf("(start_time %[1]s ? OR (start_time = ? AND id %[1]s ?))", comparison))
+		cursorTime := q.cursor.StartTime.UTC()
+		args = append(args, cursorTime, cursorTime, q.cursor.ID)
+	}
+
+	statement := `
+SELECT
+	id,
+	event_type,
+	start_time,
+	end_time,
+	notes,
+	metadata,
+	api_key_id,
+	from_integration
+FROM events`
+	statement += "\nWHERE " + strings.Join(where, " AND ")
+	statement += fmt.Sprintf("\nORDER BY start_time %[1]s, id %[1]s", strings.ToUpper(q.Order))
+
+	return statement, args
+}
+
+func (s *sqlEventStore) Query(ctx context.Context, q *EventsQuery) (*EventsPage, error) {
+	statement, args := selectEvents(q)
+
+	// Fetch one more row than requested to find out whether there is a next page.
+	statement += "\nLIMIT ?"
+	args = append(args, q.Limit+1)
+
+	events := []Event{}
+	err := s.each(ctx, statement, args, func(event *Event) error {
+		events = append(events, *event)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newEventsPage(events, q.Limit), nil
+}
+
+func (s *sqlEventStore) Export(ctx context.Context, q *EventsQuery, fn func(*Event) error) error {
+	statement, args := selectEvents(q)
+	return s.each(ctx, statement, args, fn)
+}
+
+// each calls fn with every event selected by the statement as rows are read.
+func (s *sqlEventStore) each(ctx context.Context, statement string, args []interface{}, fn func(*Event) error) error {
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *sqlEventStore) Update(ctx context.Context, id int64, patch *EventPatch) (*Event, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `
+SELECT
+	id,
+	event_type,
+	start_time,
+	end_time,
+	notes,
+	metadata,
+	api_key_id,
+	from_integration
+FROM events
+WHERE id = ? AND delete_time IS NULL
+`+s.lockClause, id)
+
+	event, err := scanEvent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errEventNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := patch.Apply(event); err != nil {
+		return nil, invalidEventError{err}
+	}
+
+	metadata, err := json.Marshal(event.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata to []byte")
+	}
+
+	endTime := event.EndTime
+	endTime.Time = endTime.Time.UTC()
+
+	if _, err := tx.ExecContext(ctx, `
+UPDATE events SET
+	end_time = ?,
+	notes = ?,
+	metadata = ?
+WHERE id = ?
+`, endTime, event.Notes, metadata, event.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	event.Metadata = json.RawMessage(metadata)
+	return event, nil
+}
+
+func (s *sqlEventStore) Delete(ctx context.Context, id int64) error {
+	result, err := s.db.ExecContext(ctx, `
+UPDATE events SET
+	delete_time = CURRENT_TIMESTAMP
+WHERE id = ? AND delete_time IS NULL
+`, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return errEventNotFound
+	}
+
+	return nil
+}
+
+func (s *sqlEventStore) ListEventTypes(ctx context.Context) ([]EventType, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT
+	name,
+	display_name,
+	color,
+	slack_channel,
+	metadata_schema
+FROM event_types
+ORDER BY name
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	eventTypes := []EventType{}
+	for rows.Next() {
+		eventType := EventType{}
+		var metadataSchema []byte
+		if err := rows.Scan(
+			&eventType.Name,
+			&eventType.DisplayName,
+			&eventType.Color,
+			&eventType.SlackChannel,
+			&metadataSchema,
+		); err != nil {
+			return nil, err
+		}
+		if len(metadataSchema) > 0 {
+			eventType.MetadataSchema = json.RawMessage(metadataSchema)
+		}
+		eventTypes = append(eventTypes, eventType)
+	}
+
+	return eventTypes, rows.Err()
+}
+
+func (s *sqlEventStore) CreateEventType(ctx context.Context, eventType *EventType) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO event_types (
+	name,
+	display_name,
+	color,
+	slack_channel,
+	metadata_schema
+) VALUES (
+	?,
+	?,
+	?,
+	?,
+	?
+)
+`, eventType.Name, eventType.DisplayName, eventType.Color, eventType.SlackChannel, nullableJSON(eventType.MetadataSchema))
+	if err != nil && s.isDuplicateKey(err) {
+		return errDuplicateEventType
+	}
+
+	return err
+}
+
+func (s *sqlEventStore) UpdateEventType(ctx context.Context, eventType *EventType) error {
+	// Look the row up first because MySQL does not count rows that were matched
+	// but left unchanged as affected.
+	var name string
+	row := s.db.QueryRowContext(ctx, `SELECT name FROM event_types WHERE name = ?`, eventType.Name)
+	if err := row.Scan(&name); errors.Is(err, sql.ErrNoRows) {
+		return errEventTypeNotFound
+	} else if err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+UPDATE event_types SET
+	display_name = ?,
+	color = ?,
+	slack_channel = ?,
+	metadata_schema = ?
+WHERE name = ?
+`, eventType.DisplayName, eventType.Color, eventType.SlackChannel, nullableJSON(eventType.MetadataSchema), eventType.Name)
+
+	return err
+}
+
+func (s *sqlEventStore) DeleteEventType(ctx context.Context, name string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM event_types WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return errEventTypeNotFound
+	}
+
+	return nil
+}
+
+// scanAPIKey reads a row selected as id, name, scopes, event_types,
+// create_time, revoke_time.
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	key := &APIKey{}
+	var scopes, eventTypes []byte
+	if err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&scopes,
+		&eventTypes,
+		&key.CreateTime,
+		&key.RevokeTime,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(scopes, &key.Scopes); err != nil {
+		return nil, fmt.Errorf("API key %s has invalid scopes: %w", key.ID, err)
+	} else if err := json.Unmarshal(eventTypes, &key.EventTypes); err != nil {
+		return nil, fmt.Errorf("API key %s has invalid event types: %w", key.ID, err)
+	}
+
+	return key, nil
+}
+
+func (s *sqlEventStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT
+	id,
+	name,
+	scopes,
+	event_types,
+	create_time,
+	revoke_time
+FROM api_keys
+ORDER BY create_time, id
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *sqlEventStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return err
+	}
+	eventTypes, err := json.Marshal(key.EventTypes)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO api_keys (
+	id,
+	name,
+	key_hash,
+	scopes,
+	event_types,
+	create_time
+) VALUES (
+	?,
+	?,
+	?,
+	?,
+	?,
+	?
+)
+`, key.ID, key.Name, key.hash, string(scopes), string(eventTypes), key.CreateTime.UTC())
+
+	return err
+}
+
+func (s *sqlEventStore) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	row := s.db.QueryRowContext(ctx, `
+SELECT
+	id,
+	name,
+	scopes,
+	event_types,
+	create_time,
+	revoke_time
+FROM api_keys
+WHERE key_hash = ? AND revoke_time IS NULL
+`, hash)
+
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errAPIKeyNotFound
+	}
+	return key, err
+}
+
+func (s *sqlEventStore) RevokeAPIKey(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `
+UPDATE api_keys SET
+	revoke_time = CURRENT_TIMESTAMP
+WHERE id = ? AND revoke_time IS NULL
+`, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return errAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (s *sqlEventStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestSQLiteStore returns a store on a migrated SQLite database in a
+// temporary directory, and the database itself.
+func newTestSQLiteStore(t *testing.T) (EventStore, *sql.DB) {
+	t.Helper()
+
+	mg := newTestSQLiteMigrator(t)
+	if err := mg.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return NewSQLiteEventStore(mg.db), mg.db
+}
+
+func TestSQLEventStoreBatchReleasesKeysOfFailedEvents(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestSQLiteStore(t)
+
+	events := []*Event{
+		{ID: 1, EventType: eventTypeExperiment, Notes: "x", StartTime: time.Now()},
+		{ID: 1, EventType: eventTypeExperiment, Notes: "y", StartTime: time.Now(), IdempotencyKey: "key"},
+	}
+	errs, err := store.InsertBatch(ctx, events, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if errs[0] != nil || !errors.Is(errs[1], errDuplicateEventID) {
+		t.Fatalf("got errors %v, want errDuplicateEventID for the second event only", errs)
+	}
+
+	// The failed event's key was not left claimed.
+	if _, err := store.GetByIdempotencyKey(ctx, "key"); !errors.Is(err, errEventNotFound) {
+		t.Errorf("GetByIdempotencyKey returned %v, want errEventNotFound", err)
+	}
+	if err := store.Insert(ctx, &Event{ID: 2, EventType: eventTypeExperiment, Notes: "y", StartTime: time.Now(), IdempotencyKey: "key"}); err != nil {
+		t.Errorf("inserting with the key of the failed event returned %v", err)
+	}
+}
+
+func TestSQLEventStoreOrphanedKey(t *testing.T) {
+	ctx := context.Background()
+	store, db := newTestSQLiteStore(t)
+
+	key := clientIdempotencyKey(ctx, "record:key")
+	if _, err := db.ExecContext(ctx, "INSERT INTO idempotency_keys (idempotency_key, event_id) VALUES (?, ?)", key, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetByIdempotencyKey(ctx, key); !errors.Is(err, errOrphanedKey) {
+		t.Errorf("GetByIdempotencyKey returned %v, want errOrphanedKey", err)
+	}
+
+	// Recording with the key fails rather than claiming success for an event
+	// that was never stored.
+	s := newTestServer(t)
+	s.store = store
+	w := s.serve(t, http.MethodPost, "/api/v0/record", map[string]interface{}{
+		"event_type": eventTypeExperiment,
+		"notes":      "x",
+	}, map[string]string{idempotencyKeyHeader: "key"})
+	expectStatus(t, w, http.StatusInternalServerError)
+}
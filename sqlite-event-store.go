@@ -0,0 +1,36 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// openSQLite opens (or creates) a SQLite database file. It is meant for local
+// development, where running MySQL is more trouble than it is worth.
+func openSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only allows a single writer at a time.
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+// NewSQLiteEventStore expects the schema to be up to date with
+// migrations/sqlite.
+func NewSQLiteEventStore(db *sql.DB) EventStore {
+	// SQLite locks the whole database for the duration of a write transaction, so
+	// no row locks are needed.
+	return &sqlEventStore{
+		db: db,
+		isDuplicateKey: func(err error) bool {
+			var sqliteErr sqlite3.Error
+			return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+		},
+	}
+}
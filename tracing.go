@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName  = "makeshift.dev/event-tracker"
+	serviceName = "event-tracker"
+
+	// traceIDMetadataKey is the metadata field that links an event to the trace
+	// of the request that recorded it.
+	traceIDMetadataKey = "trace_id"
+)
+
+// tracer is used for every span outside of the slack package. Until
+// initTracing installs a tracer provider its spans are not recorded, but the
+// trace context of incoming requests is still passed along.
+var tracer = otel.Tracer(tracerName)
+
+// initTracing exports spans to the OTLP/HTTP collector at endpoint, if there is
+// one, and returns a function that flushes them on shutdown. The exporter
+// retries failed exports with backoff.
+func initTracing(endpoint string) func(context.Context) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if len(endpoint) == 0 {
+		return func(context.Context) error { return nil }
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+"/v1/traces"),
+	)
+	if err != nil {
+		slog.Error("failed to create trace exporter", "error", err.Error())
+		return func(context.Context) error { return nil }
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown
+}
+
+// flushTraces exports any spans that are still buffered before the process
+// exits.
+func (s *server) flushTraces() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.shutdownTracing(ctx); err != nil {
+		slog.Error("failed to export traces", "error", err.Error())
+	}
+}
+
+// tracingMiddleware starts a server span for every request, continuing the
+// trace from the caller's traceparent header if there is one.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, fmt.Sprintf("%s %s", r.Method, route),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("http.target", r.URL.RequestURI()),
+			),
+		)
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.status_code", recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, strconv.Itoa(recorder.status))
+		}
+	})
+}
+
+// endSpan records err, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// addTraceID stores the ID of the trace in ctx in the event's metadata, so that
+// the event links to the request that recorded it. Metadata that is not a JSON
+// object is left alone.
+func addTraceID(ctx context.Context, event *Event) {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() || !spanContext.IsSampled() {
+		return
+	}
+
+	metadata := map[string]interface{}{}
+	if event.Metadata != nil {
+		b, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return
+		}
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.UseNumber()
+		if err := decoder.Decode(&metadata); err != nil || metadata == nil {
+			return
+		}
+	}
+
+	metadata[traceIDMetadataKey] = spanContext.TraceID().String()
+	event.Metadata = metadata
+}
@@ -0,0 +1,42 @@
+package main
+
+// VCSMetadata is the metadata of events recorded from GitLab and Bitbucket
+// webhooks. It is the same whichever system sent the webhook, so that events can
+// be queried and posted to Slack alike. Fields that do not apply to a kind of
+// webhook are left out.
+type VCSMetadata struct {
+	// VCS is the system that sent the webhook: "gitlab" or "bitbucket".
+	VCS string `json:"vcs"`
+	// VCSEvent is the kind of webhook: "push", "merge_request",
+	// "pull_request", "tag_push", "pipeline" or "deployment".
+	VCSEvent      string `json:"vcs_event"`
+	Repository    string `json:"repository"`
+	RepositoryURL string `json:"repository_url,omitempty"`
+	// Ref is a full ref such as "refs/heads/main", except for deployments, whose
+	// ref is as given to the deployment.
+	Ref    string `json:"ref,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+	SHA    string `json:"sha,omitempty"`
+	Author string `json:"author"`
+	// Action is "merged" for merge and pull requests, and "created" or
+	// "deleted" for tags.
+	Action string `json:"action,omitempty"`
+	// ID is the ID of the merge request, pull request, pipeline or deployment.
+	ID           int64       `json:"id,omitempty"`
+	Name         string      `json:"name,omitempty"`
+	Title        string      `json:"title,omitempty"`
+	Description  string      `json:"description,omitempty"`
+	URL          string      `json:"url,omitempty"`
+	SourceBranch string      `json:"source_branch,omitempty"`
+	TargetBranch string      `json:"target_branch,omitempty"`
+	Environment  string      `json:"environment,omitempty"`
+	State        string      `json:"state,omitempty"`
+	Commits      []VCSCommit `json:"commits,omitempty"`
+}
+
+type VCSCommit struct {
+	SHA     string `json:"sha"`
+	Message string `json:"message"`
+	Author  string `json:"author"`
+	URL     string `json:"url"`
+}
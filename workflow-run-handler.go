@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WorkflowRunMetadata is the metadata of events recorded from GitHub Actions
+// workflow runs.
+type WorkflowRunMetadata struct {
+	GitHubEvent string `json:"github_event"`
+	Repository  string `json:"repository"`
+	Workflow    string `json:"workflow"`
+	RunID       int64  `json:"run_id"`
+	RunNumber   int64  `json:"run_number"`
+	RunAttempt  int64  `json:"run_attempt"`
+	// Conclusion is e.g. "success", "failure", "cancelled" or "timed_out".
+	Conclusion string `json:"conclusion"`
+	Actor      string `json:"actor"`
+	HeadBranch string `json:"head_branch"`
+	HeadSHA    string `json:"head_sha"`
+	// Trigger is the GitHub event that started the run, e.g. "push".
+	Trigger string `json:"trigger"`
+	URL     string `json:"url"`
+}
+
+type WorkflowRunData struct {
+	GitHubRepositoryData
+	Action      string `json:"action"`
+	WorkflowRun struct {
+		ID           int64     `json:"id"`
+		Name         string    `json:"name"`
+		RunNumber    int64     `json:"run_number"`
+		RunAttempt   int64     `json:"run_attempt"`
+		Event        string    `json:"event"`
+		Conclusion   string    `json:"conclusion"`
+		HeadBranch   string    `json:"head_branch"`
+		HeadSHA      string    `json:"head_sha"`
+		URL          string    `json:"html_url"`
+		RunStartedAt time.Time `json:"run_started_at"`
+		UpdatedAt    time.Time `json:"updated_at"`
+		Actor        struct {
+			Login string `json:"login"`
+		} `json:"actor"`
+	} `json:"workflow_run"`
+}
+
+// WorkflowRunHandler records completed runs of the GitHub Actions workflows named
+// by --github-deploy-workflows and --github-ops-workflows. Runs of other
+// workflows are ignored.
+func (s *server) WorkflowRunHandler(w http.ResponseWriter, r *http.Request) {
+	request := WorkflowRunData{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, err, "", nil)
+		return
+	}
+
+	run := request.WorkflowRun
+	eventType, ok := s.GitHubWorkflows[run.Name]
+	if request.Action != "completed" || !ok {
+		respondWithJSON(w, http.StatusOK, nil, "", nil)
+		return
+	}
+
+	event := &Event{
+		EventType: eventType,
+		StartTime: run.RunStartedAt,
+		Notes:     fmt.Sprintf("%s #%d %s in %s", run.Name, run.RunNumber, run.Conclusion, request.Repository.FullName),
+		Metadata: &WorkflowRunMetadata{
+			GitHubEvent: workflowRunEvent,
+			Repository:  request.Repository.FullName,
+			Workflow:    run.Name,
+			RunID:       run.ID,
+			RunNumber:   run.RunNumber,
+			RunAttempt:  run.RunAttempt,
+			Conclusion:  run.Conclusion,
+			Actor:       run.Actor.Login,
+			HeadBranch:  run.HeadBranch,
+			HeadSHA:     run.HeadSHA,
+			Trigger:     run.Event,
+			URL:         run.URL,
+		},
+		// Each attempt of a run is recorded once, however often it is delivered.
+		IdempotencyKey: "github-workflow-run:" + request.Repository.FullName + ":" +
+			strconv.FormatInt(run.ID, 10) + ":" + strconv.FormatInt(run.RunAttempt, 10),
+		fromIntegration: true,
+	}
+	event.EndTime.Time = run.UpdatedAt
+	event.EndTime.Valid = true
+
+	if !s.route(event, request.Repository.FullName, "refs/heads/"+run.HeadBranch) {
+		respondWithJSON(w, http.StatusOK, nil, "", nil)
+		return
+	} else if _, ok := s.eventTypes.Lookup(event.EventType); !ok {
+		respondWithJSON(w, http.StatusOK, nil, fmt.Sprintf("event type \"%s\" is not registered", event.EventType), nil)
+		return
+	}
+
+	if err := s.writeToDBAndLog(r.Context(), event); err != nil {
+		respondWithWriteError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil, "", event)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func testWorkflowRun(name, conclusion string) map[string]interface{} {
+	return map[string]interface{}{
+		"action":     "completed",
+		"repository": map[string]interface{}{"full_name": "octo/app"},
+		"workflow_run": map[string]interface{}{
+			"id":             30433642,
+			"name":           name,
+			"run_number":     562,
+			"run_attempt":    1,
+			"event":          "push",
+			"conclusion":     conclusion,
+			"head_branch":    "main",
+			"head_sha":       "acb5820ced9479c074f688cc328bf03f341a511d",
+			"html_url":       "https://github.com/octo/app/actions/runs/30433642",
+			"run_started_at": "2024-03-01T12:00:00Z",
+			"updated_at":     "2024-03-01T12:04:00Z",
+			"actor":          map[string]interface{}{"login": "octocat"},
+		},
+	}
+}
+
+func TestWorkflowRunHandler(t *testing.T) {
+	s := newTestServer(t)
+	s.GitHubWorkflows = workflowEventTypes("Deploy", "Rotate keys")
+	slack := useFakeSlack(t, s, "#deploys")
+
+	// A deploy run has no type or machines, which the DEPLOYMENT schema requires
+	// of events recorded through the API.
+	expectStatus(t, s.serveGitHub(t, workflowRunEvent, "r1", testWorkflowRun("Deploy", "failure")), http.StatusOK)
+	expectStatus(t, s.serveGitHub(t, workflowRunEvent, "r2", testWorkflowRun("Deploy", "failure")), http.StatusOK)
+	expectStatus(t, s.serveGitHub(t, workflowRunEvent, "r3", testWorkflowRun("CI", "success")), http.StatusOK)
+
+	events := storedEvents(t, s)
+	if len(events) != 1 || events[0].EventType != eventTypeDeployment {
+		t.Fatalf("got %+v, want one DEPLOYMENT for the deploy run", events)
+	} else if metadata := metadataOf(t, events[0]); metadata["run_id"] != float64(30433642) || metadata["conclusion"] != "failure" {
+		t.Errorf("got metadata %v, want the run", metadata)
+	} else if !events[0].EndTime.Valid {
+		t.Error("the run has no end time")
+	}
+
+	if posted := slack.posted(); len(posted) != 1 || !strings.Contains(posted[0], "*FAILED: Deploy #562 in octo/app (failure)*") {
+		t.Errorf("posted %q, want the failed run", posted)
+	}
+}
+
+func TestWorkflowRunSlackTemplateNeedsRunMetadata(t *testing.T) {
+	for _, test := range []struct {
+		eventType, metadata, template string
+	}{
+		{eventTypeOpsActivity, `{"run_id": 1, "conclusion": "success"}`, "workflow_run"},
+		{eventTypeOpsActivity, `{"run_id": 1}`, "workflow_run"},
+		{eventTypeOpsActivity, `{"run_id": 1, "conclusion": false}`, "default"},
+		{eventTypeOpsActivity, `{"run_id": "", "conclusion": "success"}`, "default"},
+		{eventTypeOpsActivity, `null`, "default"},
+		{eventTypeExperiment, `{"run_id": 1}`, "default"},
+	} {
+		event := decodedEvent(t, test.eventType, test.metadata)
+		if got := slackTemplateName(event); got != test.template {
+			t.Errorf("%s %s: got template %q, want %q", test.eventType, test.metadata, got, test.template)
+		}
+	}
+}
+
+func TestRecordHandlerPostsOpsActivityWithoutMetadata(t *testing.T) {
+	s := newTestServer(t)
+	slack := useFakeSlack(t, s, "#ops")
+
+	for _, metadata := range []interface{}{
+		nil,
+		map[string]interface{}{"run_id": ""},
+		map[string]interface{}{"run_id": 1, "conclusion": false},
+	} {
+		expectStatus(t, s.serve(t, http.MethodPost, "/api/v0/record", map[string]interface{}{
+			"event_type": eventTypeOpsActivity,
+			"notes":      "rotated keys",
+			"metadata":   metadata,
+		}, nil), http.StatusOK)
+	}
+
+	if posted := slack.posted(); len(posted) != 3 {
+		t.Errorf("posted %d messages, want 3", len(posted))
+	}
+}